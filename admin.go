@@ -0,0 +1,216 @@
+package tinycache
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// adminPath 是管理接口的路径前缀，HTTPPOOL 在这个路径上提供管理接口，Server.AdminHandler 也需要挂载到这个路径上
+const adminPath = "/admin/"
+
+// WithAdminToken 设置管理接口的令牌，请求需要携带 "Authorization: Bearer <token>" 请求头。没有设置时管理接口拒绝所有请求
+func WithAdminToken(token string) Option {
+	return func(o *options) {
+		o.adminToken = token
+	}
+}
+
+// adminNode 是管理接口需要的节点信息，由 Server 和 HTTPPOOL 提供
+type adminNode struct {
+	self  string
+	token string
+	peers func() []string         // 放置算法中的所有节点，包括自己
+	owner func(key string) string // key 的拥有者，没有节点时返回空字符串
+}
+
+// adminGroup 是 /admin/groups 返回的 Group 信息
+type adminGroup struct {
+	Name          string `json:"name"`
+	CacheBytes    int64  `json:"cache_bytes"`
+	HotCacheBytes int64  `json:"hot_cache_bytes"`
+}
+
+// adminHandler 返回管理接口的 http.Handler，支持以下请求（group 和 key 通过 URL 参数传入）：
+//
+//	GET  /admin/groups                  列出所有 Group 及其容量
+//	GET  /admin/stats?group=            Group 的统计信息，group 为空时返回所有 Group
+//	GET  /admin/ring                    放置算法中的所有节点
+//	GET  /admin/owner?key=              key 的拥有者
+//	GET  /admin/peek?group=&key=        查看本节点缓存中的值，不会加载数据
+//	POST /admin/evict?group=&key=       删除本节点缓存中的 key
+//	POST /admin/flush?group=            清空本节点上 Group 的缓存
+//	GET  /admin/capacity?group=         查看 Group 的容量
+//	POST /admin/capacity?group=&bytes=  修改 Group 的容量，超出新容量的记录会被淘汰
+//	GET  /admin/hotkeys?group=&n=       本节点的热点 key，group 为空时返回所有 Group
+func adminHandler(n adminNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.token == "" {
+			http.Error(w, "admin API is disabled", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+n.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		action := strings.TrimPrefix(r.URL.Path, adminPath)
+		method := http.MethodGet
+		switch action {
+		case "evict", "flush":
+			method = http.MethodPost
+		case "capacity":
+			if r.Method == http.MethodPost {
+				method = http.MethodPost
+			}
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+
+		switch action {
+		case "groups":
+			list := sortedGroups()
+			res := make([]adminGroup, len(list))
+			for i, g := range list {
+				res[i] = adminGroup{Name: g.name, CacheBytes: g.mainCache.capacity(), HotCacheBytes: g.hotCache.capacity()}
+			}
+			writeJSON(w, res)
+		case "stats":
+			list := sortedGroups()
+			if name := q.Get("group"); name != "" {
+				g, ok := adminGroupParam(w, name)
+				if !ok {
+					return
+				}
+				list = []*Group{g}
+			}
+			res := make([]GroupStats, len(list))
+			for i, g := range list {
+				res[i] = g.Stats()
+			}
+			writeJSON(w, res)
+		case "ring":
+			peers := n.peers()
+			sort.Strings(peers)
+			writeJSON(w, map[string]interface{}{"self": n.self, "peers": peers})
+		case "owner":
+			key := q.Get("key")
+			if key == "" {
+				http.Error(w, "key is required", http.StatusBadRequest)
+				return
+			}
+			owner := n.owner(key)
+			writeJSON(w, map[string]interface{}{"key": key, "owner": owner, "local": owner == "" || owner == n.self})
+		case "peek":
+			g, ok := adminGroupParam(w, q.Get("group"))
+			if !ok {
+				return
+			}
+			value, source, ok := g.PeekWithSource(q.Get("key"))
+			if !ok {
+				http.Error(w, "key not cached", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set(headerVersion, strconv.FormatUint(value.version, 10))
+			w.Header().Set("X-Tinycache-Source", source.String())
+			w.Write(value.ByteSlice())
+		case "evict":
+			g, ok := adminGroupParam(w, q.Get("group"))
+			if !ok {
+				return
+			}
+			if err := g.Evict(q.Get("key")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "flush":
+			g, ok := adminGroupParam(w, q.Get("group"))
+			if !ok {
+				return
+			}
+			g.Flush()
+			w.WriteHeader(http.StatusNoContent)
+		case "capacity":
+			g, ok := adminGroupParam(w, q.Get("group"))
+			if !ok {
+				return
+			}
+			if r.Method == http.MethodPost {
+				bytes, err := strconv.ParseInt(q.Get("bytes"), 10, 64)
+				if err != nil || bytes < 0 {
+					http.Error(w, "bad bytes: "+q.Get("bytes"), http.StatusBadRequest)
+					return
+				}
+				g.SetCapacity(bytes)
+			}
+			writeJSON(w, adminGroup{Name: g.name, CacheBytes: g.mainCache.capacity(), HotCacheBytes: g.hotCache.capacity()})
+		case "hotkeys":
+			serveHotKeys(w, q)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// Evict 删除 key 在本节点 hotCache 和 mainCache 中的副本，不修改数据源，也不通知其他节点。
+// 与 Invalidate 一样，删除之前发起的加载不会再把旧值写回缓存
+func (g *Group) Evict(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.applyInvalidation(key, g.nextSeq())
+	return nil
+}
+
+// Flush 清空本节点上 Group 的 hotCache 和 mainCache，不会触发 OnEvict。
+// 与 Evict 一样，清空之前发起的加载不会再把旧值写回缓存
+func (g *Group) Flush() {
+	g.inval.mu.Lock()
+	g.inval.all = g.nextSeqLocked()
+	g.mainCache.clear()
+	g.hotCache.clear()
+	g.inval.mu.Unlock()
+	leases.forgetGroup(g.name)
+}
+
+// SetCapacity 修改 mainCache 的容量，hotCache 的容量为它的 1/8，与 NewGroup 相同。超出新容量的记录会被淘汰
+func (g *Group) SetCapacity(cacheBytes int64) {
+	g.mainCache.setCapacity(cacheBytes)
+	g.hotCache.setCapacity(cacheBytes / 8)
+}
+
+// adminGroupParam 返回名为 name 的 Group，不存在时写出错误响应并返回 false
+func adminGroupParam(w http.ResponseWriter, name string) (*Group, bool) {
+	if name == "" {
+		http.Error(w, "group is required", http.StatusBadRequest)
+		return nil, false
+	}
+	g := GetGroup(name)
+	if g == nil {
+		http.Error(w, "no such group: "+name, http.StatusNotFound)
+		return nil, false
+	}
+	return g, true
+}
+
+// sortedGroups 按名字排序返回所有 Group
+func sortedGroups() []*Group {
+	list := allGroups()
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
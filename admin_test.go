@@ -0,0 +1,159 @@
+package tinycache
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// adminDo 以 token 请求管理接口，返回状态码和响应内容
+func adminDo(t *testing.T, h http.Handler, method, target, token string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	body, _ := io.ReadAll(rec.Body)
+	return rec.Code, string(body)
+}
+
+func TestAdminAuth(t *testing.T) {
+	pool := NewHTTPPool("http://127.0.0.1")
+	if code, _ := adminDo(t, pool, http.MethodGet, "/admin/groups", "secret"); code != http.StatusForbidden {
+		t.Fatalf("admin API without token configured: got %d", code)
+	}
+	pool = NewHTTPPool("http://127.0.0.1", WithAdminToken("secret"))
+	if code, _ := adminDo(t, pool, http.MethodGet, "/admin/groups", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: got %d", code)
+	}
+	if code, _ := adminDo(t, pool, http.MethodGet, "/admin/groups", "secret"); code != http.StatusOK {
+		t.Fatalf("valid token: got %d", code)
+	}
+	if code, _ := adminDo(t, pool, http.MethodGet, "/admin/flush?group=x", "secret"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET flush: got %d", code)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	g := NewGroup("admin", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	pool := NewHTTPPool("http://node1", WithAdminToken("secret"))
+	pool.Set("http://node1", "http://node2")
+	do := func(method, target string, wantCode int) string {
+		t.Helper()
+		code, body := adminDo(t, pool, method, target, "secret")
+		if code != wantCode {
+			t.Fatalf("%s %s: got %d, want %d: %s", method, target, code, wantCode, body)
+		}
+		return body
+	}
+
+	var groups []adminGroup
+	json.Unmarshal([]byte(do(http.MethodGet, "/admin/groups", http.StatusOK)), &groups)
+	found := false
+	for _, info := range groups {
+		found = found || reflect.DeepEqual(info, adminGroup{Name: "admin", CacheBytes: 2 << 10, HotCacheBytes: 2 << 10 / 8})
+	}
+	if !found {
+		t.Fatalf("groups should contain admin, got %+v", groups)
+	}
+
+	var ring struct {
+		Self  string
+		Peers []string
+	}
+	json.Unmarshal([]byte(do(http.MethodGet, "/admin/ring", http.StatusOK)), &ring)
+	if ring.Self != "http://node1" || !reflect.DeepEqual(ring.Peers, []string{"http://node1", "http://node2"}) {
+		t.Fatalf("unexpected ring: %+v", ring)
+	}
+	var owner struct {
+		Owner string
+		Local bool
+	}
+	json.Unmarshal([]byte(do(http.MethodGet, "/admin/owner?key=Tom", http.StatusOK)), &owner)
+	if owner.Owner != pool.peers.Get("Tom") || owner.Local != (owner.Owner == "http://node1") {
+		t.Fatalf("unexpected owner: %+v", owner)
+	}
+
+	// peek 不会加载数据
+	do(http.MethodGet, "/admin/peek?group=admin&key=Tom", http.StatusNotFound)
+	g.Get("Tom")
+	if body := do(http.MethodGet, "/admin/peek?group=admin&key=Tom", http.StatusOK); body != "630" {
+		t.Fatalf("peek: got %q", body)
+	}
+	var stats []GroupStats
+	json.Unmarshal([]byte(do(http.MethodGet, "/admin/stats?group=admin", http.StatusOK)), &stats)
+	if len(stats) != 1 || stats[0].Gets != 1 || stats[0].LocalLoads != 1 || stats[0].MainCache.Items != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	do(http.MethodPost, "/admin/evict?group=admin&key=Tom", http.StatusNoContent)
+	do(http.MethodGet, "/admin/peek?group=admin&key=Tom", http.StatusNotFound)
+
+	g.Get("Tom")
+	g.Get("Jack")
+	do(http.MethodPost, "/admin/flush?group=admin", http.StatusNoContent)
+	if keys := g.Keys(""); len(keys) != 0 {
+		t.Fatalf("keys after flush: %v", keys)
+	}
+
+	// 缩小容量后只能容纳一条记录，淘汰最久未使用的 Tom
+	g.Get("Tom")
+	g.Get("Jack")
+	body := do(http.MethodPost, "/admin/capacity?group=admin&bytes=8", http.StatusOK)
+	if !strings.Contains(body, `"cache_bytes":8`) || !reflect.DeepEqual(g.Keys(""), []string{"Jack"}) {
+		t.Fatalf("capacity: %s keys=%v", body, g.Keys(""))
+	}
+	do(http.MethodPost, "/admin/capacity?group=admin&bytes=-1", http.StatusBadRequest)
+	do(http.MethodGet, "/admin/stats?group=admin-unknown", http.StatusNotFound)
+	do(http.MethodGet, "/admin/unknown", http.StatusNotFound)
+}
+
+func TestServerAdminHandler(t *testing.T) {
+	svr, _ := NewServer("127.0.0.1:1", WithAdminToken("secret"))
+	svr.Set("127.0.0.1:1", "127.0.0.1:2")
+	code, body := adminDo(t, svr.AdminHandler(), http.MethodGet, "/admin/ring", "secret")
+	if code != http.StatusOK || !strings.Contains(body, `"peers":["127.0.0.1:1","127.0.0.1:2"]`) {
+		t.Fatalf("ring: %d %s", code, body)
+	}
+}
+
+// Flush 之前发起的加载不能把旧值写回缓存
+func TestFlushDuringLoad(t *testing.T) {
+	loading, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("admin-flush-inflight", 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			close(loading)
+			<-release
+			return []byte("stale"), nil
+		}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := g.Get("Tom"); err != nil || v.String() != "stale" {
+			t.Errorf("in-flight load should still return its value, got %v %v", v, err)
+		}
+	}()
+	<-loading
+	g.Flush()
+	close(release)
+	<-done
+
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("value loaded before flush should not be cached")
+	}
+	if _, err := g.Set("Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := g.mainCache.get("Tom"); !ok || v.String() != "630" {
+		t.Fatalf("writes after flush should be cached, got %v %v", v, ok)
+	}
+}
@@ -0,0 +1,452 @@
+package tinycache
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// ErrCircuitOpen 远程节点的熔断器处于打开状态，请求没有发出。它会被包装成传输错误，由失败处理策略决定是否回退
+var ErrCircuitOpen = errors.New("tinycache: circuit breaker is open")
+
+// BreakerConfig 熔断器配置。只有传输错误计入失败，远程节点返回的业务错误说明节点本身可用
+type BreakerConfig struct {
+	FailureThreshold int           // 连续失败多少次后打开熔断器，默认 5
+	OpenTimeout      time.Duration // 打开多久后进入半开状态放行探测请求，默认 5s
+	HalfOpenProbes   int           // 半开状态同时放行的探测请求数，探测全部成功后关闭熔断器，默认 1
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 5 * time.Second
+	}
+	if c.HalfOpenProbes <= 0 {
+		c.HalfOpenProbes = 1
+	}
+	return c
+}
+
+// RetryPolicy 传输错误的重试策略，第 n 次重试前等待 [0, min(MaxDelay, BaseDelay*2^n)) 之间的随机时间
+type RetryPolicy struct {
+	MaxAttempts int           // 包括第一次在内的最多请求次数，<=1 表示不重试
+	BaseDelay   time.Duration // 第一次重试的退避上限，默认 20ms
+	MaxDelay    time.Duration // 退避上限，默认 1s
+}
+
+func (r RetryPolicy) withDefaults() RetryPolicy {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = 1
+	}
+	if r.BaseDelay <= 0 {
+		r.BaseDelay = 20 * time.Millisecond
+	}
+	if r.MaxDelay <= 0 {
+		r.MaxDelay = time.Second
+	}
+	return r
+}
+
+// backoff 返回第 attempt 次重试前的等待时间（full jitter）
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	d := r.MaxDelay
+	if attempt < 30 {
+		if exp := r.BaseDelay << uint(attempt); exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// HedgePolicy 对冲请求配置：拥有者在最近请求延迟的 Percentile 分位数内没有返回时，
+// 同时向下一个候选节点发出相同请求，取先成功的结果
+type HedgePolicy struct {
+	Percentile float64       // 延迟分位数，取值 (0,1]，默认 0.95
+	MinDelay   time.Duration // 对冲前至少等待的时间，样本不足时也使用它，默认 10ms
+}
+
+func (h HedgePolicy) withDefaults() HedgePolicy {
+	if h.Percentile <= 0 || h.Percentile > 1 {
+		h.Percentile = 0.95
+	}
+	if h.MinDelay <= 0 {
+		h.MinDelay = 10 * time.Millisecond
+	}
+	return h
+}
+
+// breakerState 熔断器状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // 正常放行
+	breakerOpen                         // 拒绝所有请求
+	breakerHalfOpen                     // 放行少量探测请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker 单个远程节点的熔断器
+type circuitBreaker struct {
+	cfg      BreakerConfig
+	mu       sync.Mutex
+	state    breakerState
+	failures int       // 关闭状态下的连续失败次数
+	openedAt time.Time // 进入打开状态的时间
+	probes   int       // 半开状态下还没返回的探测请求数
+	passed   int       // 半开状态下已成功的探测请求数
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg.withDefaults()}
+}
+
+// allow 判断是否放行请求，放行后必须调用 record 报告结果
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return false
+		}
+		b.state, b.probes, b.passed = breakerHalfOpen, 0, 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes+b.passed >= b.cfg.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// record 报告请求结果，只有传输错误计为失败
+func (b *circuitBreaker) record(err error) {
+	failed := IsTransportError(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		if b.failures++; b.failures >= b.cfg.FailureThreshold {
+			b.trip()
+		}
+	case breakerHalfOpen:
+		b.probes--
+		if failed {
+			b.trip()
+			return
+		}
+		if b.passed++; b.passed >= b.cfg.HalfOpenProbes {
+			b.state, b.failures = breakerClosed, 0
+		}
+	}
+}
+
+// trip 打开熔断器，调用方需持有 b.mu
+func (b *circuitBreaker) trip() {
+	b.state, b.openedAt, b.failures = breakerOpen, time.Now(), 0
+}
+
+// current 返回熔断器当前状态
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// latencyWindow 记录最近若干次成功请求的延迟，用于计算对冲的等待时间
+const latencyWindow = 128
+
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	n       int // 已记录的样本总数
+}
+
+func (t *latencyTracker) observe(d time.Duration) {
+	t.mu.Lock()
+	t.samples[t.n%latencyWindow] = d
+	t.n++
+	t.mu.Unlock()
+}
+
+// percentile 返回最近样本的 p 分位数，没有样本时返回 0
+func (t *latencyTracker) percentile(p float64) time.Duration {
+	t.mu.Lock()
+	n := t.n
+	if n > latencyWindow {
+		n = latencyWindow
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, t.samples[:n])
+	t.mu.Unlock()
+	if n == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	i := int(p*float64(n)+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= n {
+		i = n - 1
+	}
+	return samples[i]
+}
+
+// peerGuard 保存访问单个远程节点的熔断器、重试策略和延迟统计，Client 和 httpGetter 共用
+type peerGuard struct {
+	breaker *circuitBreaker // 为 nil 表示不熔断
+	retry   RetryPolicy
+	latency latencyTracker
+	hist    histogram // 每次请求的耗时，包括失败的请求，由 /metrics 导出
+}
+
+func newPeerGuard(o options) *peerGuard {
+	g := &peerGuard{retry: o.retry.withDefaults()}
+	if o.breaker != nil {
+		g.breaker = newCircuitBreaker(*o.breaker)
+	}
+	return g
+}
+
+// do 在熔断器允许时执行 call，传输错误按重试策略退避重试。ctx 结束时不再等待重试，返回最后一次的错误
+func (g *peerGuard) do(ctx context.Context, call func() error) error {
+	return g.doRetry(ctx, call, nil)
+}
+
+// stream 同 do，用于分段传输：call 已经向 w 写出部分数据后失败时不再重试，避免 w 中出现重复的数据
+func (g *peerGuard) stream(ctx context.Context, w io.Writer, call func(w io.Writer) (*pb.Response, error)) (*pb.Response, error) {
+	cw := &countingWriter{w: w}
+	var res *pb.Response
+	err := g.doRetry(ctx, func() (err error) {
+		res, err = call(cw)
+		return err
+	}, func() bool { return cw.n == 0 })
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// countingWriter 记录写出的字节数
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// doRetry 同 do，retryable 不为 nil 且返回 false 时传输错误也不再重试
+func (g *peerGuard) doRetry(ctx context.Context, call func() error, retryable func() bool) error {
+	if g == nil {
+		return call()
+	}
+	var err error
+	for attempt := 0; attempt < g.retry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(g.retry.backoff(attempt - 1))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+		}
+		if g.breaker != nil && !g.breaker.allow() {
+			if err == nil {
+				err = &transportError{err: ErrCircuitOpen}
+			}
+			return err
+		}
+		start := time.Now()
+		err = call()
+		g.hist.observe(time.Since(start))
+		if g.breaker != nil {
+			g.breaker.record(err)
+		}
+		if !IsTransportError(err) {
+			if err == nil {
+				g.latency.observe(time.Since(start))
+			}
+			return err
+		}
+		if retryable != nil && !retryable() {
+			return err
+		}
+	}
+	return err
+}
+
+// guarded 由带有 peerGuard 的远程节点客户端实现
+type guarded interface {
+	guard() *peerGuard
+}
+
+// hedgedPeer 访问拥有者，超过延迟分位数仍未返回时向下一个候选节点发出对冲请求
+type hedgedPeer struct {
+	primary PeerGetter
+	replica PeerGetter
+	policy  HedgePolicy
+}
+
+// newHedgedPeer 在配置了对冲且存在下一个候选节点时包装拥有者，否则直接返回拥有者
+func newHedgedPeer(primary, replica PeerGetter, policy *HedgePolicy) PeerGetter {
+	if policy == nil || replica == nil {
+		return primary
+	}
+	return &hedgedPeer{primary: primary, replica: replica, policy: policy.withDefaults()}
+}
+
+// delay 返回发出对冲请求前的等待时间
+func (h *hedgedPeer) delay() time.Duration {
+	d := h.policy.MinDelay
+	if g, ok := h.primary.(guarded); ok {
+		if p := g.guard().latency.percentile(h.policy.Percentile); p > d {
+			d = p
+		}
+	}
+	return d
+}
+
+func (h *hedgedPeer) Get(in *pb.Request, out *pb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+// GetContext 同 Get，ctx 同时传给拥有者和下一个候选节点。发给下一个候选节点的请求标记为对冲请求，
+// 它不会再转发给拥有者。先返回成功结果的请求胜出，另一个请求随即被取消
+func (h *hedgedPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	type result struct {
+		res *pb.Response
+		err error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hedgeReq := proto.Clone(in).(*pb.Request)
+	hedgeReq.Hedged = true
+	results := make(chan result, 2)
+	call := func(peer PeerGetter, req *pb.Request) {
+		res := &pb.Response{}
+		err := getFromPeerContext(ctx, peer, req, res)
+		results <- result{res: res, err: err}
+	}
+
+	go call(h.primary, in)
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				go call(h.replica, hedgeReq)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				proto.Merge(out, r.res)
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if !IsTransportError(r.err) {
+				return r.err // 业务错误不会因为换一个节点而改变
+			}
+			if !hedged {
+				// 拥有者已经失败，不必再等
+				hedged = true
+				pending++
+				go call(h.replica, hedgeReq)
+			}
+		}
+	}
+	return firstErr
+}
+
+// GetStream 转发给拥有者。分段传输用于拥有者上的大对象，不对冲
+func (h *hedgedPeer) GetStream(in *pb.Request, w io.Writer) (*pb.Response, error) {
+	return h.GetStreamContext(context.Background(), in, w)
+}
+
+// GetStreamContext 同 GetStream
+func (h *hedgedPeer) GetStreamContext(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error) {
+	res, ok, err := getStreamFromPeerContext(ctx, h.primary, in, w)
+	if !ok {
+		return nil, errors.New("tinycache: peer does not support streaming")
+	}
+	return res, err
+}
+
+// Invalidate 转发给拥有者
+func (h *hedgedPeer) Invalidate(in *pb.InvalidateRequest) error {
+	if inv, ok := h.primary.(PeerInvalidator); ok {
+		return inv.Invalidate(in)
+	}
+	return errors.New("tinycache: peer does not support invalidation")
+}
+
+// Set 转发给拥有者
+func (h *hedgedPeer) Set(in *pb.SetRequest) (*pb.SetResponse, error) {
+	if setter, ok := h.primary.(PeerSetter); ok {
+		return setter.Set(in)
+	}
+	return nil, errors.New("tinycache: peer does not support set")
+}
+
+// AcquireLease 转发给 primary
+func (h *hedgedPeer) AcquireLease(in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	if leaser, ok := h.primary.(PeerLeaser); ok {
+		return leaser.AcquireLease(in)
+	}
+	return &pb.LeaseResponse{Granted: true}, nil
+}
+
+// ReleaseLease 转发给拥有者
+func (h *hedgedPeer) ReleaseLease(in *pb.ReleaseRequest) error {
+	if leaser, ok := h.primary.(PeerLeaser); ok {
+		return leaser.ReleaseLease(in)
+	}
+	return nil
+}
+
+var (
+	_ PeerGetter      = (*hedgedPeer)(nil)
+	_ PeerInvalidator = (*hedgedPeer)(nil)
+	_ PeerLeaser      = (*hedgedPeer)(nil)
+	_ PeerSetter      = (*hedgedPeer)(nil)
+	_ PeerStreamer    = (*hedgedPeer)(nil)
+
+	_ PeerContextGetter   = (*hedgedPeer)(nil)
+	_ PeerContextStreamer = (*hedgedPeer)(nil)
+)
@@ -0,0 +1,210 @@
+package tinycache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond})
+	down := &transportError{err: fmt.Errorf("connection refused")}
+
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("closed breaker should allow requests")
+		}
+		b.record(down)
+	}
+	if b.current() != breakerOpen || b.allow() {
+		t.Fatalf("breaker should open after 2 failures, got %v", b.current())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() || b.current() != breakerHalfOpen {
+		t.Fatalf("breaker should let a probe through after OpenTimeout, got %v", b.current())
+	}
+	if b.allow() {
+		t.Fatalf("half-open breaker should only allow 1 probe")
+	}
+	b.record(down)
+	if b.current() != breakerOpen {
+		t.Fatalf("failed probe should reopen the breaker, got %v", b.current())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	b.allow()
+	b.record(fmt.Errorf("key not found")) // 业务错误说明节点可用
+	if b.current() != breakerClosed {
+		t.Fatalf("successful probe should close the breaker, got %v", b.current())
+	}
+}
+
+func TestPeerGuardRetry(t *testing.T) {
+	o := defaultOptions()
+	WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})(&o)
+	g := newPeerGuard(o)
+
+	calls := 0
+	err := g.do(context.Background(), func() error {
+		if calls++; calls < 3 {
+			return &transportError{err: fmt.Errorf("connection reset")}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("transport errors should be retried, calls=%d err=%v", calls, err)
+	}
+
+	calls = 0
+	if err = g.do(context.Background(), func() error { calls++; return fmt.Errorf("key not found") }); err == nil || calls != 1 {
+		t.Fatalf("application errors should not be retried, calls=%d", calls)
+	}
+}
+
+func TestPeerGuardRetryContext(t *testing.T) {
+	o := defaultOptions()
+	WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})(&o)
+	g := newPeerGuard(o)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	calls, start := 0, time.Now()
+	err := g.do(ctx, func() error { calls++; return &transportError{err: fmt.Errorf("connection reset")} })
+	if !IsTransportError(err) || calls != 1 || time.Since(start) > time.Second {
+		t.Fatalf("backoff should stop at the deadline, calls=%d err=%v after %v", calls, err, time.Since(start))
+	}
+}
+
+// 分段传输已经写出部分数据后不能重试，否则 w 中会出现重复的数据
+func TestPeerGuardStream(t *testing.T) {
+	o := defaultOptions()
+	WithRetry(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})(&o)
+	g := newPeerGuard(o)
+
+	var buf bytes.Buffer
+	calls := 0
+	_, err := g.stream(context.Background(), &buf, func(w io.Writer) (*pb.Response, error) {
+		calls++
+		w.Write([]byte("part"))
+		return nil, &transportError{err: fmt.Errorf("connection reset")}
+	})
+	if !IsTransportError(err) || calls != 1 || buf.String() != "part" {
+		t.Fatalf("partial stream should not be retried, calls=%d buf=%q err=%v", calls, buf.String(), err)
+	}
+
+	calls = 0
+	res, err := g.stream(context.Background(), &buf, func(w io.Writer) (*pb.Response, error) {
+		if calls++; calls < 3 {
+			return nil, &transportError{err: fmt.Errorf("connection refused")}
+		}
+		return &pb.Response{Version: 1}, nil
+	})
+	if err != nil || calls != 3 || res.Version != 1 {
+		t.Fatalf("stream that wrote nothing should be retried, calls=%d err=%v", calls, err)
+	}
+}
+
+func TestHTTPGetterBreaker(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	o := defaultOptions()
+	WithCircuitBreaker(BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})(&o)
+	getter := newHTTPGetter(server.URL+defaultPath, o)
+	for i := 0; i < 5; i++ {
+		err := getter.Get(&pb.Request{Group: "scores", Key: "Tom"}, &pb.Response{})
+		if !IsTransportError(err) {
+			t.Fatalf("unavailable peer should return a transport error, got %v", err)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("open breaker should stop requests to the peer, got %d requests", requests)
+	}
+	err := getter.Get(&pb.Request{Group: "scores", Key: "Tom"}, &pb.Response{})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+}
+
+// slowPeer 等待 delay 后返回固定的值
+type slowPeer struct {
+	stubPeer
+	delay time.Duration
+}
+
+func (p *slowPeer) Get(in *pb.Request, out *pb.Response) error {
+	time.Sleep(p.delay)
+	return p.stubPeer.Get(in, out)
+}
+
+// blockingPeer 一直等到请求被取消
+type blockingPeer struct {
+	stubPeer
+	canceled chan struct{}
+}
+
+func (p *blockingPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	<-ctx.Done()
+	close(p.canceled)
+	return &transportError{err: ctx.Err()}
+}
+
+// hedgeRecorder 记录收到的请求是否标记为对冲请求
+type hedgeRecorder struct {
+	stubPeer
+	hedged bool
+}
+
+func (p *hedgeRecorder) Get(in *pb.Request, out *pb.Response) error {
+	p.hedged = in.Hedged
+	return p.stubPeer.Get(in, out)
+}
+
+func TestHedgedPeer(t *testing.T) {
+	primary := &slowPeer{stubPeer: stubPeer{value: "primary"}, delay: 500 * time.Millisecond}
+	replica := &stubPeer{value: "replica"}
+	peer := newHedgedPeer(primary, replica, &HedgePolicy{MinDelay: 10 * time.Millisecond})
+
+	start := time.Now()
+	out := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "scores", Key: "Tom"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Value) != "replica" || time.Since(start) > 200*time.Millisecond {
+		t.Fatalf("slow primary should be hedged to the replica, got %q after %v", out.Value, time.Since(start))
+	}
+
+	// 拥有者不可用时不必等待就发出对冲请求
+	down := &stubPeer{err: &transportError{err: fmt.Errorf("connection refused")}}
+	peer = newHedgedPeer(down, replica, &HedgePolicy{MinDelay: time.Minute})
+	out = &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "scores", Key: "Tom"}, out); err != nil || string(out.Value) != "replica" {
+		t.Fatalf("failed primary should be hedged immediately, got %q %v", out.Value, err)
+	}
+
+	// 发给下一个候选节点的请求带有对冲标记，胜出后取消拥有者上的请求
+	blocked := &blockingPeer{canceled: make(chan struct{})}
+	recorder := &hedgeRecorder{stubPeer: stubPeer{value: "replica"}}
+	peer = newHedgedPeer(blocked, recorder, &HedgePolicy{MinDelay: time.Millisecond})
+	in := &pb.Request{Group: "scores", Key: "Tom"}
+	if err := peer.Get(in, &pb.Response{}); err != nil || !recorder.hedged || in.Hedged {
+		t.Fatalf("replica should receive a hedged copy of the request, hedged=%v err=%v", recorder.hedged, err)
+	}
+	select {
+	case <-blocked.canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("losing request should be canceled")
+	}
+}
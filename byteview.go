@@ -1,12 +1,20 @@
 package tinycache
 
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
 // 抽象出一个只读数据结构用来表示缓存值，使用[]byte可以表示任意数据结构类型的存储
 // 1. ByteView 只有一个数据成员，b []byte，b 将会存储真实的缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等。
 // 2. 实现 Len() int 方法，我们在 Cache 的实现中，要求被缓存对象必须实现 Value 接口，即 Len() int 方法，返回其所占的内存大小。
 // 3. b 是只读的，使用 ByteSlice() 方法返回一个拷贝，防止缓存值被外部程序修改。
 
 type ByteView struct {
-	b []byte //b 将会存储真实的缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等。
+	b        []byte    //b 将会存储真实的缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等。
+	version  uint64    //版本号，版本号越大数据越新，用于在多个副本之间判断新旧
+	loadedAt time.Time //数据从数据源加载（或被写入）的时间
 }
 
 func (v ByteView) Len() int {
@@ -23,6 +31,35 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// Reader 返回读取缓存值的 io.Reader，不会拷贝缓存值，适合分段发送大对象
+func (v ByteView) Reader() io.Reader {
+	return bytes.NewReader(v.b)
+}
+
+// WriteTo 把缓存值写入 w，实现 io.WriterTo，不会拷贝缓存值
+func (v ByteView) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(v.b)
+	return int64(n), err
+}
+
+// Version 返回缓存值的版本号，版本号越大数据越新
+func (v ByteView) Version() uint64 {
+	return v.version
+}
+
+// LoadTime 返回缓存值从数据源加载（或被写入）的时间
+func (v ByteView) LoadTime() time.Time {
+	return v.loadedAt
+}
+
+// newerThan 判断 v 是否比 o 新：版本号大的更新，版本号相同时字节序大的胜出，保证各节点得出相同的结论
+func (v ByteView) newerThan(o ByteView) bool {
+	if v.version != o.version {
+		return v.version > o.version
+	}
+	return bytes.Compare(v.b, o.b) > 0
+}
+
 // cloneBytes 用于创建并返回一个输入字节切片（[]byte）的副本。
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
@@ -7,10 +7,94 @@ import (
 	"tinycache/strategy/lru"
 )
 
-// BaseCache 是一个接口，定义了基本的缓存操作方法。它包含了两个方法：add 和 get，用于向缓存中添加数据和从缓存中获取数据。
+// BaseCache 是一个接口，定义了基本的缓存操作方法。add 和 get 用于向缓存中添加数据（ttl<=0 时使用默认过期时间）和从缓存中获取数据，
+// peek 同 get 但不改变访问顺序或频率，也不删除过期数据，
+// remove 用于删除数据，forEach 用于遍历所有未过期的数据（例如节点变化时迁移数据），clear 删除所有数据并释放内存，
+// counters 返回淘汰和过期的计数以及删除事件的回调，capacity 和 setCapacity 读取和修改最大容量，
+// usage 返回当前的记录数和占用的字节数（包含已过期但还没有删除的记录），不遍历缓存。
 type BaseCache interface {
-	add(key string, value ByteView)
+	add(key string, value ByteView, ttl time.Duration)
 	get(key string) (value ByteView, ok bool)
+	peek(key string) (value ByteView, ok bool)
+	remove(key string)
+	forEach(fn func(key string, value ByteView) bool)
+	clear()
+	counters() *cacheCounters
+	capacity() int64
+	setCapacity(bytes int64)
+	usage() (items int, bytes int64)
+}
+
+// cacheCounters 记录缓存因容量不足淘汰和因过期删除的记录数（主动删除不计入），并把删除事件转发给 onRemove。
+// 删除事件在持有缓存的锁时产生，先暂存起来，释放锁之后由 notify 通知，回调中可以再次访问缓存。
+// Group 持有自己的内部锁期间用 hold 暂停通知，释放内部锁后由 release 统一通知
+type cacheCounters struct {
+	evictions   AtomicInt
+	expirations AtomicInt
+	onRemove    func(key string, value ByteView, reason EvictReason) // 由 Group 在创建时设置，可以为 nil
+	pendingMu   sync.Mutex
+	pending     []removal
+	holds       int // 暂停通知的次数，大于 0 时 notify 只暂存
+}
+
+// removal 是一条等待通知的删除事件
+type removal struct {
+	key    string
+	value  ByteView
+	reason EvictReason
+}
+
+func (c *cacheCounters) counters() *cacheCounters {
+	return c
+}
+
+// removed 由淘汰算法的回调调用，调用方持有缓存的锁
+func (c *cacheCounters) removed(key string, value ByteView, reason EvictReason) {
+	switch reason {
+	case EvictCapacity:
+		c.evictions.Add(1)
+	case EvictExpired:
+		c.expirations.Add(1)
+	}
+	if c.onRemove == nil {
+		return
+	}
+	c.pendingMu.Lock()
+	c.pending = append(c.pending, removal{key: key, value: value, reason: reason})
+	c.pendingMu.Unlock()
+}
+
+// notify 通知暂存的删除事件，调用方不能持有缓存的锁。通知被 hold 暂停时什么也不做
+func (c *cacheCounters) notify() {
+	if c.onRemove == nil {
+		return
+	}
+	c.pendingMu.Lock()
+	if c.holds > 0 {
+		c.pendingMu.Unlock()
+		return
+	}
+	pending := c.pending
+	c.pending = nil
+	c.pendingMu.Unlock()
+	for _, r := range pending {
+		c.onRemove(r.key, r.value, r.reason)
+	}
+}
+
+// hold 暂停通知删除事件，直到对应的 release
+func (c *cacheCounters) hold() {
+	c.pendingMu.Lock()
+	c.holds++
+	c.pendingMu.Unlock()
+}
+
+// release 恢复通知，并通知暂停期间暂存的删除事件，调用方不能持有缓存的锁和 Group 的内部锁
+func (c *cacheCounters) release() {
+	c.pendingMu.Lock()
+	c.holds--
+	c.pendingMu.Unlock()
+	c.notify()
 }
 
 // LRUcache 的实现非常简单，实例化 lru，封装 get 和 add 方法。
@@ -19,27 +103,35 @@ type LRUcache struct {
 	lru        *lru.LRUCache
 	cacheBytes int64         // lru的maxBytes
 	ttl        time.Duration // lru的defaultTTL
+	cacheCounters
 }
 
 // add 函数用于向缓存中添加数据
-func (c *LRUcache) add(key string, value ByteView) {
-	c.mu.Lock() //写锁
+func (c *LRUcache) add(key string, value ByteView, ttl time.Duration) {
+	defer c.notify() // 在释放锁之后通知删除事件
+	c.mu.Lock()      //写锁
 	defer c.mu.Unlock()
 	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil, c.ttl)
+		c.lru = lru.New(c.cacheBytes, func(key string, v lru.Value) { c.removed(key, v.(ByteView), EvictCapacity) }, c.ttl)
+		c.lru.OnExpired = func(key string, v lru.Value) { c.removed(key, v.(ByteView), EvictExpired) }
+		c.lru.OnRemoved = func(key string, v lru.Value) { c.removed(key, v.(ByteView), EvictExplicit) }
 	}
 	/*
 		判断c.lru 是否为 nil，如果等于 nil 再创建实例。
 		这种方法称之为延迟初始化(Lazy Initialization)，一个对象的延迟初始化意味着该对象的创建将会延迟至第一次使用该对象时。
 		主要用于提高性能，并减少程序内存要求。
 	.*/
-	c.lru.Add(key, value, c.ttl)
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	c.lru.Add(key, value, ttl)
 }
 
 // get 函数用于从缓存中获取数据
 func (c *LRUcache) get(key string) (value ByteView, ok bool) {
-	c.mu.RLock() //读锁
-	defer c.mu.RUnlock()
+	defer c.notify()
+	c.mu.Lock() // Get 会调整访问顺序并删除过期记录，需要写锁
+	defer c.mu.Unlock()
 	if c.lru == nil {
 		return
 	}
@@ -49,33 +141,183 @@ func (c *LRUcache) get(key string) (value ByteView, ok bool) {
 	return
 }
 
+// peek 函数用于查看缓存中的数据，不改变访问顺序，只需要读锁
+func (c *LRUcache) peek(key string) (value ByteView, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return
+	}
+	if v, ok := c.lru.Peek(key); ok {
+		return v.(ByteView), ok
+	}
+	return
+}
+
+// remove 函数用于从缓存中删除数据
+func (c *LRUcache) remove(key string) {
+	defer c.notify()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return
+	}
+	c.lru.Remove(key)
+}
+
+// clear 函数删除所有数据，下次 add 时重新创建
+func (c *LRUcache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru = nil
+}
+
+// capacity 函数返回最大容量
+func (c *LRUcache) capacity() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cacheBytes
+}
+
+// usage 函数返回当前的记录数和占用的字节数
+func (c *LRUcache) usage() (items int, bytes int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return 0, 0
+	}
+	return c.lru.Len(), c.lru.Bytes()
+}
+
+// setCapacity 函数修改最大容量，超出新容量的记录会被淘汰
+func (c *LRUcache) setCapacity(bytes int64) {
+	defer c.notify()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheBytes = bytes
+	if c.lru != nil {
+		c.lru.SetMaxBytes(bytes)
+	}
+}
+
+// forEach 函数用于遍历缓存中的数据，fn 返回 false 时停止遍历
+func (c *LRUcache) forEach(fn func(key string, value ByteView) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lru == nil {
+		return
+	}
+	c.lru.Range(func(key string, value lru.Value) bool {
+		return fn(key, value.(ByteView))
+	})
+}
+
 // LFUcache 同理于LRUcache
 type LFUcache struct {
 	mu         sync.RWMutex
 	lfu        *lfu.LFUCache
 	cacheBytes int64
 	ttl        time.Duration
+	cacheCounters
 }
 
 // add 函数用于向缓存中添加数据
-func (c *LFUcache) add(key string, value ByteView) {
+func (c *LFUcache) add(key string, value ByteView, ttl time.Duration) {
+	defer c.notify() // 在释放锁之后通知删除事件
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lfu == nil {
-		c.lfu = lfu.New(c.cacheBytes, nil, c.ttl)
+		c.lfu = lfu.New(c.cacheBytes, func(key string, v lfu.Value) { c.removed(key, v.(ByteView), EvictCapacity) }, c.ttl)
+		c.lfu.OnExpired = func(key string, v lfu.Value) { c.removed(key, v.(ByteView), EvictExpired) }
+		c.lfu.OnRemoved = func(key string, v lfu.Value) { c.removed(key, v.(ByteView), EvictExplicit) }
 	}
-	c.lfu.Add(key, value, c.ttl)
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	c.lfu.Add(key, value, ttl)
 }
 
 // get 函数用于从缓存中获取数据
 func (c *LFUcache) get(key string) (value ByteView, ok bool) {
+	defer c.notify()
+	c.mu.Lock() // Get 会调整访问频率并删除过期记录，需要写锁
+	defer c.mu.Unlock()
+	if c.lfu == nil {
+		return
+	}
+	if v, ok := c.lfu.Get(key); ok {
+		return v.(ByteView), ok
+	}
+	return
+}
+
+// peek 函数用于查看缓存中的数据，不改变访问频率，只需要读锁
+func (c *LFUcache) peek(key string) (value ByteView, ok bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	if c.lfu == nil {
 		return
 	}
-	if v, ok := c.lfu.Get(key); ok {
+	if v, ok := c.lfu.Peek(key); ok {
 		return v.(ByteView), ok
 	}
 	return
 }
+
+// remove 函数用于从缓存中删除数据
+func (c *LFUcache) remove(key string) {
+	defer c.notify()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lfu == nil {
+		return
+	}
+	c.lfu.Remove(key)
+}
+
+// clear 函数删除所有数据，下次 add 时重新创建
+func (c *LFUcache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lfu = nil
+}
+
+// capacity 函数返回最大容量
+func (c *LFUcache) capacity() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cacheBytes
+}
+
+// usage 函数返回当前的记录数和占用的字节数
+func (c *LFUcache) usage() (items int, bytes int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lfu == nil {
+		return 0, 0
+	}
+	return c.lfu.Len(), c.lfu.Bytes()
+}
+
+// setCapacity 函数修改最大容量，超出新容量的记录会被淘汰
+func (c *LFUcache) setCapacity(bytes int64) {
+	defer c.notify()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cacheBytes = bytes
+	if c.lfu != nil {
+		c.lfu.SetMaxBytes(bytes)
+	}
+}
+
+// forEach 函数用于遍历缓存中的数据，fn 返回 false 时停止遍历
+func (c *LFUcache) forEach(fn func(key string, value ByteView) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.lfu == nil {
+		return
+	}
+	c.lfu.Range(func(key string, value lfu.Value) bool {
+		return fn(key, value.(ByteView))
+	})
+}
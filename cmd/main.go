@@ -96,8 +96,11 @@ func createGroup() *tinycache.Group {
 		}))
 }
 
-// startAPIServer 启动一个 API 服务器，用于与用户进行交互。用户可以通过访问 /api?key=XXX 的形式来获取缓存数据。
-func startAPIServer(apiAddr string, gee *tinycache.Group) {
+// startAPIServer 启动一个 API 服务器，用于与用户进行交互。用户可以通过访问 /api?key=XXX 的形式来获取缓存数据，
+// 通过 /metrics 获取 Prometheus 指标，通过 /admin/ 查看和管理节点（需要 -admin-token）。
+func startAPIServer(apiAddr string, gee *tinycache.Group, metrics, admin http.Handler) {
+	http.Handle("/metrics", metrics)
+	http.Handle("/admin/", admin)
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
@@ -118,8 +121,10 @@ func startAPIServer(apiAddr string, gee *tinycache.Group) {
 func startGRPCServer() {
 	var port int
 	var api bool
+	var adminToken string
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.StringVar(&adminToken, "admin-token", "", "Token of the admin API, empty to disable it")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
@@ -135,19 +140,19 @@ func startGRPCServer() {
 	}
 
 	gee := createGroup()
+	peers, _ := tinycache.NewServer(addrMap[port], tinycache.WithAdminToken(adminToken))
 	if api {
-		go startAPIServer(apiAddr, gee)
+		go startAPIServer(apiAddr, gee, peers.MetricsHandler(), peers.AdminHandler())
 	}
-	startCacheServerGrpcEtcd(addrMap[port], addrs, gee)
+	startCacheServerGrpcEtcd(peers, addrMap[port], addrs, gee)
 }
 
 // startCacheServerGrpcEtcd 函数：
-// 创建一个 Server 实例，该实例用于处理 gRPC 请求并与其他节点通信。
+// peers 是用于处理 gRPC 请求并与其他节点通信的 Server 实例。
 // 通过Set 方法设置一组节点地址。
 // 将实例注册到缓存组（group）中。
 // 启动Server 实例，开始处理 gRPC 请求。
-func startCacheServerGrpcEtcd(addr string, addrs []string, group *tinycache.Group) {
-	peers, _ := tinycache.NewServer(addr)
+func startCacheServerGrpcEtcd(peers *tinycache.Server, addr string, addrs []string, group *tinycache.Group) {
 	peers.Set(addrs...)
 	group.RegisterPeers(peers)
 	log.Println("TinyCache is running at ", addr)
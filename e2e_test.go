@@ -0,0 +1,221 @@
+package tinycache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	pb "tinycache/tinycachepb"
+)
+
+// startTransports 在本进程中同时启动 gRPC 服务和 HTTP 服务，两者访问相同的 Group
+func startTransports(t *testing.T, opts ...Option) (grpcAddr, httpURL string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, _ := NewServer(lis.Addr().String(), opts...)
+	go svr.Serve(lis)
+	t.Cleanup(func() { svr.Shutdown(context.Background()) })
+
+	ts := httptest.NewServer(NewHTTPPool("http://127.0.0.1", opts...))
+	t.Cleanup(ts.Close)
+	return lis.Addr().String(), ts.URL
+}
+
+func TestTransportsEndToEnd(t *testing.T) {
+	NewGroup("e2e", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	grpcAddr, httpURL := startTransports(t)
+
+	o := defaultOptions()
+	WithDirectDial()(&o)
+	client := newClient("geecache/"+grpcAddr, o)
+	getter := newHTTPGetter(httpURL+defaultPath, o)
+
+	for key, want := range db {
+		in := &pb.Request{Group: "e2e", Key: key}
+		viaGRPC, viaHTTP := &pb.Response{}, &pb.Response{}
+		if err := client.Get(in, viaGRPC); err != nil {
+			t.Fatalf("grpc get %s: %v", key, err)
+		}
+		if err := getter.Get(in, viaHTTP); err != nil {
+			t.Fatalf("http get %s: %v", key, err)
+		}
+		if string(viaGRPC.Value) != want || string(viaHTTP.Value) != want {
+			t.Fatalf("get %s: grpc=%q http=%q, want %q", key, viaGRPC.Value, viaHTTP.Value, want)
+		}
+		if viaGRPC.Version != viaHTTP.Version || viaGRPC.LoadTime != viaHTTP.LoadTime {
+			t.Fatalf("both transports should return the same cached entry for %s", key)
+		}
+		if viaGRPC.Protocol != ProtocolVersion || viaHTTP.Protocol != ProtocolVersion {
+			t.Fatalf("expected protocol %d, got grpc=%d http=%d", ProtocolVersion, viaGRPC.Protocol, viaHTTP.Protocol)
+		}
+	}
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	NewGroup("e2e-legacy", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	grpcAddr, _ := startTransports(t)
+
+	conn, err := grpc.Dial(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 旧版本客户端不携带协议版本，服务端返回二次编码的响应
+	res, err := pb.NewGroupCacheClient(conn).Get(context.Background(), &pb.Request{Group: "e2e-legacy", Key: "Tom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Protocol != 0 || string(res.Value) == "630" {
+		t.Fatalf("legacy client should get a double-encoded response, got %+v", res)
+	}
+	out := &pb.Response{}
+	if err = decodeResponse(res, out); err != nil || string(out.Value) != "630" || out.Version == 0 {
+		t.Fatalf("legacy response should decode to the value, got %+v %v", out, err)
+	}
+
+	// 新版本客户端直接拿到缓存值
+	res, err = pb.NewGroupCacheClient(conn).Get(context.Background(), &pb.Request{Group: "e2e-legacy", Key: "Tom", Protocol: ProtocolVersion})
+	if err != nil || string(res.Value) != "630" || res.Protocol != ProtocolVersion {
+		t.Fatalf("current client should get the value directly, got %+v %v", res, err)
+	}
+}
+
+func TestGetStream(t *testing.T) {
+	report := bytes.Repeat([]byte("0123456789abcdef"), 5<<16) // 5MB，超过 gRPC 单条消息的默认上限
+	NewGroup("e2e-stream", 16<<20, "lru", GetterFunc(func(key string) ([]byte, error) {
+		if key == "empty" {
+			return []byte{}, nil
+		}
+		return report, nil
+	}))
+	grpcAddr, httpURL := startTransports(t)
+
+	o := defaultOptions()
+	WithDirectDial()(&o)
+	streamers := map[string]PeerStreamer{
+		"grpc": newClient("geecache/"+grpcAddr, o),
+		"http": newHTTPGetter(httpURL+defaultPath, o),
+	}
+	for name, streamer := range streamers {
+		var buf bytes.Buffer
+		res, err := streamer.GetStream(&pb.Request{Group: "e2e-stream", Key: "report"}, &buf)
+		if err != nil {
+			t.Fatalf("%s stream: %v", name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), report) || res.Version == 0 {
+			t.Fatalf("%s stream: got %d bytes version %d, want %d bytes", name, buf.Len(), res.Version, len(report))
+		}
+
+		buf.Reset()
+		if _, err = streamer.GetStream(&pb.Request{Group: "e2e-stream", Key: "empty"}, &buf); err != nil || buf.Len() != 0 {
+			t.Fatalf("%s stream of empty value: %d bytes, %v", name, buf.Len(), err)
+		}
+	}
+
+	// 大对象的一元 Get 超过消息大小限制，这不是传输错误；Group 访问远程节点时改用分段传输
+	client := streamers["grpc"].(*Client)
+	if err := client.Get(&pb.Request{Group: "e2e-stream", Key: "report"}, &pb.Response{}); !isMessageTooLarge(err) || IsTransportError(err) {
+		t.Fatalf("oversized unary get should fail with ResourceExhausted, got %v", err)
+	}
+	front := NewGroup("e2e-stream-front", 16<<20, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return nil, fmt.Errorf("%s should be loaded from the peer", key)
+	}))
+	front.RegisterPeers(&stubPicker{owner: &groupPeer{client: client, group: "e2e-stream"}})
+	view, source, err := front.GetWithSource("report")
+	if err != nil || source != SourcePeer || !bytes.Equal(view.ByteSlice(), report) {
+		t.Fatalf("group should stream oversized values from the peer, got %d bytes from %v: %v", view.Len(), source, err)
+	}
+}
+
+// groupPeer 把请求转发给远程节点上名为 group 的 Group
+type groupPeer struct {
+	client *Client
+	group  string
+}
+
+func (p *groupPeer) rename(in *pb.Request) *pb.Request {
+	return &pb.Request{Group: p.group, Key: in.Key}
+}
+
+func (p *groupPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.client.Get(p.rename(in), out)
+}
+
+func (p *groupPeer) GetStreamContext(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error) {
+	return p.client.GetStreamContext(ctx, p.rename(in), w)
+}
+
+func TestServiceRPCs(t *testing.T) {
+	NewGroup("e2e-rpc", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s not exist", key)
+	}))
+	grpcAddr, _ := startTransports(t)
+	conn, err := grpc.Dial(grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, ctx := pb.NewGroupCacheClient(conn), context.Background()
+
+	set, err := client.Set(ctx, &pb.SetRequest{Group: "e2e-rpc", Key: "Lily", Value: []byte("99"), TtlMs: 60000})
+	if err != nil || !set.Applied || set.Version == 0 {
+		t.Fatalf("set: %+v %v", set, err)
+	}
+	cas, err := client.Set(ctx, &pb.SetRequest{Group: "e2e-rpc", Key: "Lily", Value: []byte("100"),
+		Options: &pb.SetOptions{CompareAndSet: true, ExpectedVersion: set.Version - 1}})
+	if err != nil || cas.Applied || cas.Version != set.Version {
+		t.Fatalf("compare-and-set with a stale version should not apply: %+v %v", cas, err)
+	}
+
+	multi, err := client.GetMulti(ctx, &pb.GetMultiRequest{Group: "e2e-rpc", Keys: []string{"Lily", "Tom", "unknown"}})
+	if err != nil || len(multi.Values) != 3 {
+		t.Fatalf("get multi: %+v %v", multi, err)
+	}
+	if string(multi.Values[0].Value) != "99" || string(multi.Values[1].Value) != "630" || multi.Values[2].Error == "" {
+		t.Fatalf("unexpected get multi results: %+v", multi.Values)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	svr, _ := NewServer("127.0.0.1:0")
+	if _, err = svr.GetMulti(cancelled, &pb.GetMultiRequest{Group: "e2e-rpc", Keys: []string{"Lily", "Tom"}}); status.Code(err) != codes.Canceled {
+		t.Fatalf("get multi with a cancelled context should stop, got %v", err)
+	}
+
+	keys, err := client.ListKeys(ctx, &pb.ListKeysRequest{Group: "e2e-rpc", Limit: 1})
+	if err != nil || len(keys.Keys) != 1 || keys.Keys[0] != "Lily" || !keys.Truncated {
+		t.Fatalf("list keys first page: %+v %v", keys, err)
+	}
+	keys, err = client.ListKeys(ctx, &pb.ListKeysRequest{Group: "e2e-rpc", StartAfter: "Lily"})
+	if err != nil || len(keys.Keys) != 1 || keys.Keys[0] != "Tom" || keys.Truncated {
+		t.Fatalf("list keys second page: %+v %v", keys, err)
+	}
+
+	stats, err := client.Stats(ctx, &pb.StatsRequest{Group: "e2e-rpc"})
+	if err != nil || len(stats.Groups) != 1 || stats.Groups[0].MainCache.Items != 2 {
+		t.Fatalf("stats: %+v %v", stats, err)
+	}
+
+	if _, err = client.Delete(ctx, &pb.DeleteRequest{Group: "e2e-rpc", Key: "Lily"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ = client.ListKeys(ctx, &pb.ListKeysRequest{Group: "e2e-rpc"}); len(keys.Keys) != 1 {
+		t.Fatalf("deleted key should be removed from the cache, got %v", keys.Keys)
+	}
+}
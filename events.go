@@ -0,0 +1,106 @@
+package tinycache
+
+import (
+	"time"
+)
+
+// EvictReason 表示数据从缓存中删除的原因
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota + 1 // 缓存容量不足，被淘汰算法淘汰
+	EvictExpired                         // 超过过期时间
+	EvictExplicit                        // 主动删除，例如 Set、Delete、失效或迁移数据
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictExplicit:
+		return "explicit"
+	}
+	return "unknown"
+}
+
+// Listener 监听 Group 中缓存的生命周期事件，可以用于指标、审计日志或二级索引，为 nil 的字段表示不关心该事件。
+// 回调同步执行，应尽快返回。OnEvict 在 Group 释放内部锁之后执行，可以调用同一个 Group 的方法；
+// Group 持锁期间其他 goroutine 产生的删除事件会暂存，由最后释放锁的 goroutine 通知
+type Listener struct {
+	OnHit        func(key string, source Source)                                    // 命中 hotCache 或 mainCache
+	OnMiss       func(key string)                                                   // hotCache 和 mainCache 都没有命中
+	OnLoad       func(key string, source Source, latency time.Duration, err error)  // 加载完成，合并到其他请求的加载不会触发
+	OnEvict      func(key string, value ByteView, cache Source, reason EvictReason) // 数据从 hotCache 或 mainCache 中删除，清空缓存不会触发
+	OnHotPromote func(key string, value ByteView)                                   // 远程节点的热点数据被写入 hotCache
+	OnPeerError  func(key string, err error)                                        // 从远程节点获取数据失败
+}
+
+// WithListener 为 Group 增加一个事件监听，可以多次使用，按增加的顺序通知
+func WithListener(l Listener) GroupOption {
+	return func(g *Group) {
+		g.listeners = append(g.listeners, l)
+	}
+}
+
+// watchEvictions 在有监听 OnEvict 时，把缓存的删除事件转发给监听者
+func (g *Group) watchEvictions() {
+	for _, l := range g.listeners {
+		if l.OnEvict != nil {
+			g.mainCache.counters().onRemove = g.evictNotifier(SourceMainCache)
+			g.hotCache.counters().onRemove = g.evictNotifier(SourceHotCache)
+			return
+		}
+	}
+}
+
+func (g *Group) evictNotifier(cache Source) func(key string, value ByteView, reason EvictReason) {
+	return func(key string, value ByteView, reason EvictReason) {
+		for _, l := range g.listeners {
+			if l.OnEvict != nil {
+				l.OnEvict(key, value, cache, reason)
+			}
+		}
+	}
+}
+
+func (g *Group) notifyHit(key string, source Source) {
+	for _, l := range g.listeners {
+		if l.OnHit != nil {
+			l.OnHit(key, source)
+		}
+	}
+}
+
+func (g *Group) notifyMiss(key string) {
+	for _, l := range g.listeners {
+		if l.OnMiss != nil {
+			l.OnMiss(key)
+		}
+	}
+}
+
+func (g *Group) notifyLoad(key string, source Source, latency time.Duration, err error) {
+	for _, l := range g.listeners {
+		if l.OnLoad != nil {
+			l.OnLoad(key, source, latency, err)
+		}
+	}
+}
+
+func (g *Group) notifyHotPromote(key string, value ByteView) {
+	for _, l := range g.listeners {
+		if l.OnHotPromote != nil {
+			l.OnHotPromote(key, value)
+		}
+	}
+}
+
+func (g *Group) notifyPeerError(key string, err error) {
+	for _, l := range g.listeners {
+		if l.OnPeerError != nil {
+			l.OnPeerError(key, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package tinycache
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventLog 把监听到的事件记录为字符串
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *eventLog) add(format string, args ...interface{}) {
+	e.mu.Lock()
+	e.events = append(e.events, fmt.Sprintf(format, args...))
+	e.mu.Unlock()
+}
+
+func (e *eventLog) take() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	events := e.events
+	e.events = nil
+	return events
+}
+
+func (e *eventLog) listener() Listener {
+	return Listener{
+		OnHit:  func(key string, source Source) { e.add("hit %s %s", key, source) },
+		OnMiss: func(key string) { e.add("miss %s", key) },
+		OnLoad: func(key string, source Source, latency time.Duration, err error) {
+			e.add("load %s %s %v", key, source, err)
+		},
+		OnEvict: func(key string, value ByteView, cache Source, reason EvictReason) {
+			e.add("evict %s %s %s", key, cache, reason)
+		},
+		OnHotPromote: func(key string, value ByteView) { e.add("promote %s %s", key, value) },
+		OnPeerError:  func(key string, err error) { e.add("peer error %s %v", key, err) },
+	}
+}
+
+func TestGroupListener(t *testing.T) {
+	var log eventLog
+	g := NewGroup("events", 36, "lru", GetterFunc(func(key string) ([]byte, error) {
+		if key == "missing" {
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(strings.Repeat("v", 10)), nil
+	}), WithListener(log.listener()))
+
+	check := func(want ...string) {
+		t.Helper()
+		if got := log.take(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("events: got %q, want %q", got, want)
+		}
+	}
+
+	g.Get("k1")
+	g.Get("k1")
+	g.Get("missing")
+	check("miss k1", "load k1 local <nil>", "hit k1 mainCache",
+		"miss missing", "load missing local missing not exist")
+
+	// 容量为 36 字节，第 4 个 12 字节的记录写入时淘汰最久未使用的 k1
+	g.Get("k2")
+	g.Get("k3")
+	g.Get("k4")
+	check("miss k2", "load k2 local <nil>", "miss k3", "load k3 local <nil>",
+		"miss k4", "evict k1 mainCache capacity", "load k4 local <nil>")
+
+	g.Invalidate("k2")
+	check("evict k2 mainCache explicit")
+
+	g.Set("short", []byte("v"), WithTTL(time.Millisecond))
+	log.take()
+	time.Sleep(2 * time.Millisecond)
+	g.Get("short")
+	check("evict short mainCache expired", "miss short", "evict k3 mainCache capacity", "load short local <nil>")
+}
+
+func TestGroupListenerPeer(t *testing.T) {
+	var log eventLog
+	loads := 0
+	g := newFailoverGroup("events-peer", FailurePolicy{}, &stubPicker{owner: &stubPeer{err: fmt.Errorf("boom")}}, &loads)
+	g.listeners = append(g.listeners, log.listener())
+	g.Get("Tom")
+	if got := log.take(); !containsEvent(got, "peer error Tom boom") {
+		t.Fatalf("unexpected events: %q", got)
+	}
+
+	g.peers = &stubPicker{owner: &stubPeer{value: "630"}}
+	defer func(qps int) { maxMinuteRemoteQPS = qps }(maxMinuteRemoteQPS)
+	maxMinuteRemoteQPS = 2
+	g.Get("Jack")
+	g.Get("Jack")
+	if got := log.take(); !containsEvent(got, "promote Jack 630") {
+		t.Fatalf("unexpected events: %q", got)
+	}
+}
+
+// OnEvict 在 Group 释放内部锁之后执行，回调中可以读取、失效和写入同一个 Group
+func TestListenerReentrant(t *testing.T) {
+	var g *Group
+	evicted := make(chan string, 16)
+	g = NewGroup("events-reentrant", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithListener(Listener{OnEvict: func(key string, value ByteView, cache Source, reason EvictReason) {
+		if key == "Tom" {
+			g.Get("Jack")
+			g.Invalidate("Sam")
+			g.Set("Tom", []byte("700"))
+		}
+		evicted <- key
+	}}))
+
+	done := make(chan struct{})
+	go func() {
+		g.Get("Tom")
+		g.Invalidate("Tom") // 在 g.inval.mu 内删除 mainCache 中的 Tom
+		g.Set("Tom", []byte("701"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("OnEvict calling back into the group should not deadlock")
+	}
+	if key := <-evicted; key != "Tom" {
+		t.Fatalf("expected Tom to be evicted first, got %s", key)
+	}
+}
+
+func containsEvent(events []string, want string) bool {
+	for _, e := range events {
+		if e == want {
+			return true
+		}
+	}
+	return false
+}
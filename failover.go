@@ -0,0 +1,176 @@
+package tinycache
+
+import (
+	"context"
+	"errors"
+)
+
+// Source 表示一次 Get 请求的数据来自哪里
+type Source int
+
+const (
+	SourceHotCache  Source = iota + 1 // 本节点的 hotCache
+	SourceMainCache                   // 本节点的 mainCache
+	SourceLocal                       // 本节点是拥有者，从数据源加载
+	SourcePeer                        // 拥有者节点
+	SourceSuccessor                   // 拥有者失败后，放置算法给出的下一个节点
+	SourceFallback                    // 远程节点失败后，本节点从数据源加载（或使用其他节点持有租约加载的结果）
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceHotCache:
+		return "hotCache"
+	case SourceMainCache:
+		return "mainCache"
+	case SourceLocal:
+		return "local"
+	case SourcePeer:
+		return "peer"
+	case SourceSuccessor:
+		return "successor"
+	case SourceFallback:
+		return "fallback"
+	}
+	return "unknown"
+}
+
+// FailurePolicy 决定访问拥有者失败时的处理方式
+type FailurePolicy struct {
+	TrySuccessor       bool // 拥有者发生传输错误时，先尝试放置算法给出的下一个节点
+	LoadLocally        bool // 远程节点都失败时，在本节点从数据源加载
+	PopulateLocal      bool // 本地加载的结果写入本节点的 mainCache。本节点不是拥有者，默认不写入，避免同一个key在多个节点的 mainCache 中各有一份
+	FallbackOnAppError bool // 拥有者返回业务错误（例如数据源中没有这个key）时也回退，默认直接把错误返回给调用方，避免重复访问数据源
+}
+
+// DefaultFailurePolicy 是 Group 默认的失败处理方式：只在传输错误时回退到本地加载，且不写入 mainCache
+var DefaultFailurePolicy = FailurePolicy{LoadLocally: true}
+
+// transportError 表示访问远程节点时的传输层错误（连接失败、超时、节点不可用等），
+// 与远程节点正常处理请求后返回的业务错误区分开
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// IsTransportError 判断 err 是否为访问远程节点时的传输层错误，传输层错误说明节点可能不可用，可以尝试其他节点
+func IsTransportError(err error) bool {
+	var te *transportError
+	return errors.As(err, &te)
+}
+
+// SuccessorPicker 是 PeerPicker 的可选扩展，返回拥有者之后的下一个候选节点。
+// 下一个候选节点是自身或不存在时返回 false
+type SuccessorPicker interface {
+	PickSuccessor(key string) (peer PeerGetter, ok bool)
+}
+
+// hedgedKey 是 context 中标记对冲请求的key
+type hedgedKey struct{}
+
+// withHedged 标记 ctx 所属的请求是其他节点发来的对冲请求
+func withHedged(ctx context.Context) context.Context {
+	return context.WithValue(ctx, hedgedKey{}, true)
+}
+
+// isHedged 判断 ctx 所属的请求是否为对冲请求。对冲请求是因为拥有者响应慢才发过来的，不能再转发给拥有者
+func isHedged(ctx context.Context) bool {
+	hedged, _ := ctx.Value(hedgedKey{}).(bool)
+	return hedged
+}
+
+// loadResult 是 singleflight 共享给所有等待者的加载结果
+type loadResult struct {
+	value  ByteView
+	source Source
+}
+
+// fetch 按失败处理策略加载 key：
+//  1. 没有远程节点或自己是拥有者时，持租约从数据源加载并写入 mainCache
+//  2. 否则访问拥有者；拥有者返回业务错误时直接返回（除非配置了 FallbackOnAppError）
+//  3. 拥有者发生传输错误时，按配置尝试下一个候选节点
+//  4. 仍然失败时，按配置持下一个候选节点发放的租约在本地加载，默认不写入 mainCache
+func (g *Group) fetch(ctx context.Context, key string) (ByteView, Source, error) {
+	if g.peers == nil {
+		value, err := g.getLocally(ctx, key, true)
+		return value, SourceLocal, err
+	}
+	peer, ok := g.peers.PickPeer(key) //根据key选择远程节点
+	if !ok {
+		// 自己是拥有者，同样需要租约，避免和其他回退到本地加载的节点同时访问数据源
+		value, err := g.loadWithLease(ctx, leases, key, true)
+		return value, SourceLocal, err
+	}
+	if isHedged(ctx) {
+		// 发来对冲请求的节点已经在等拥有者，这里直接从数据源加载
+		value, err := g.loadFallback(ctx, key, false)
+		return value, SourceFallback, err
+	}
+	value, err := g.getFromPeer(ctx, peer, key) //从远程节点获取数据
+	if err == nil {
+		return value, SourcePeer, nil
+	}
+	g.log.get().Warn("get from peer failed", "group", g.name, keyHash(key), "err", err)
+	if !g.shouldFallback(err) {
+		return ByteView{}, SourcePeer, err
+	}
+
+	successorDown := false
+	if sp, ok := g.peers.(SuccessorPicker); ok && g.policy.TrySuccessor {
+		if successor, ok := sp.PickSuccessor(key); ok {
+			if value, err = g.getFromPeer(ctx, successor, key); err == nil {
+				return value, SourceSuccessor, nil
+			}
+			g.log.get().Warn("get from successor failed", "group", g.name, keyHash(key), "err", err)
+			if !g.shouldFallback(err) {
+				return ByteView{}, SourceSuccessor, err
+			}
+			successorDown = IsTransportError(err)
+		}
+	}
+
+	if !g.policy.LoadLocally {
+		return ByteView{}, SourcePeer, err
+	}
+	value, err = g.loadFallback(ctx, key, successorDown)
+	return value, SourceFallback, err
+}
+
+// loadFallback 在不访问拥有者的情况下从数据源加载 key
+func (g *Group) loadFallback(ctx context.Context, key string, successorDown bool) (ByteView, error) {
+	if leaser := g.fallbackLeaser(key, successorDown); leaser != nil {
+		// 拥有者不可用，向下一个候选节点申请租约，整个集群只有一个节点访问数据源
+		return g.loadWithLease(ctx, leaser, key, g.policy.PopulateLocal)
+	}
+	return g.getLocally(ctx, key, g.policy.PopulateLocal)
+}
+
+// fallbackLeaser 返回拥有者不可用时发放租约的节点：所有节点都选择放置算法给出的下一个候选节点，
+// 它是自己（或不存在）时使用本进程的租约表。下一个候选节点同样发生了传输错误或不支持租约时返回 nil
+func (g *Group) fallbackLeaser(key string, successorDown bool) PeerLeaser {
+	sp, ok := g.peers.(SuccessorPicker)
+	if !ok {
+		return nil
+	}
+	successor, ok := sp.PickSuccessor(key)
+	if !ok {
+		return leases
+	}
+	if successorDown {
+		return nil
+	}
+	leaser, _ := successor.(PeerLeaser)
+	return leaser
+}
+
+// shouldFallback 判断访问远程节点失败后是否继续尝试其他方式
+func (g *Group) shouldFallback(err error) bool {
+	return IsTransportError(err) || g.policy.FallbackOnAppError
+}
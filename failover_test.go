@@ -0,0 +1,147 @@
+package tinycache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// stubPeer 总是返回固定的值或错误，delay 模拟网络延迟
+type stubPeer struct {
+	value string
+	err   error
+	delay time.Duration
+}
+
+func (p *stubPeer) Get(in *pb.Request, out *pb.Response) error {
+	time.Sleep(p.delay)
+	if p.err != nil {
+		return p.err
+	}
+	out.Value = []byte(p.value)
+	return nil
+}
+
+// stubPicker 把所有key都交给 owner，successor 为下一个候选节点
+type stubPicker struct {
+	owner, successor PeerGetter
+}
+
+func (p *stubPicker) PickPeer(key string) (PeerGetter, bool) { return p.owner, true }
+
+func (p *stubPicker) PickSuccessor(key string) (PeerGetter, bool) {
+	return p.successor, p.successor != nil
+}
+
+func newFailoverGroup(name string, policy FailurePolicy, picker PeerPicker, loads *int) *Group {
+	g := NewGroup(name, 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			*loads++
+			return []byte(db[key]), nil
+		}), WithFailurePolicy(policy))
+	g.RegisterPeers(picker)
+	return g
+}
+
+func TestFailoverAppError(t *testing.T) {
+	loads := 0
+	owner := &stubPeer{err: fmt.Errorf("Tom not exist")}
+	g := newFailoverGroup("failover-app", DefaultFailurePolicy, &stubPicker{owner: owner}, &loads)
+	if _, err := g.Get("Tom"); err == nil || loads != 0 {
+		t.Fatalf("application error from owner should be returned without loading locally, loads=%d", loads)
+	}
+}
+
+func TestFailoverTransportError(t *testing.T) {
+	loads := 0
+	owner := &stubPeer{err: &transportError{err: fmt.Errorf("connection refused")}}
+	g := newFailoverGroup("failover-local", DefaultFailurePolicy, &stubPicker{owner: owner}, &loads)
+	view, source, err := g.GetWithSource("Tom")
+	if err != nil || view.String() != "630" || source != SourceFallback {
+		t.Fatalf("transport error should fall back to local load, got %v %v %v", view, source, err)
+	}
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("fallback load should not populate mainCache by default")
+	}
+
+	policy := DefaultFailurePolicy
+	policy.PopulateLocal = true
+	g = newFailoverGroup("failover-populate", policy, &stubPicker{owner: owner}, &loads)
+	g.Get("Tom")
+	if _, source, _ := g.GetWithSource("Tom"); source != SourceMainCache {
+		t.Fatalf("PopulateLocal should cache the fallback load, got %v", source)
+	}
+}
+
+func TestFailoverHedged(t *testing.T) {
+	loads := 0
+	owner := &stubPeer{value: "slow"}
+	g := newFailoverGroup("failover-hedged", DefaultFailurePolicy, &stubPicker{owner: owner}, &loads)
+	view, source, err := g.getWithSource(withHedged(context.Background()), "Tom")
+	if err != nil || view.String() != "630" || source != SourceFallback || loads != 1 {
+		t.Fatalf("hedged request should be loaded locally instead of forwarded to the owner, got %v %v %v loads=%d", view, source, err, loads)
+	}
+}
+
+func TestFailoverSuccessor(t *testing.T) {
+	loads := 0
+	owner := &stubPeer{err: &transportError{err: fmt.Errorf("connection refused")}}
+	successor := &stubPeer{value: "630"}
+	g := newFailoverGroup("failover-successor", FailurePolicy{TrySuccessor: true}, &stubPicker{owner: owner, successor: successor}, &loads)
+	view, source, err := g.GetWithSource("Tom")
+	if err != nil || view.String() != "630" || source != SourceSuccessor || loads != 0 {
+		t.Fatalf("should be served by successor, got %v %v %v loads=%d", view, source, err, loads)
+	}
+
+	// 下一个候选节点也失败，且不允许本地加载时返回错误
+	successor.err = &transportError{err: fmt.Errorf("connection refused")}
+	if _, err := g.Get("Sam"); err == nil || loads != 0 {
+		t.Fatalf("should fail without LoadLocally, loads=%d", loads)
+	}
+}
+
+// leasingPeer 是同时发放租约的 stubPeer，记录收到的租约申请次数
+type leasingPeer struct {
+	stubPeer
+	table    *leaseTable
+	acquired int
+}
+
+func (p *leasingPeer) AcquireLease(in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	p.acquired++
+	return p.table.acquire(in), nil
+}
+
+func (p *leasingPeer) ReleaseLease(in *pb.ReleaseRequest) error {
+	p.table.release(in)
+	return nil
+}
+
+// 拥有者不可用时，租约由下一个候选节点发放，而不是同样不可用的拥有者
+func TestFailoverLeaseFromSuccessor(t *testing.T) {
+	loads := 0
+	down := &transportError{err: fmt.Errorf("connection refused")}
+	owner := &leasingPeer{stubPeer: stubPeer{err: down}, table: &leaseTable{leases: make(map[string]*fillLease)}}
+	successor := &leasingPeer{table: &leaseTable{leases: make(map[string]*fillLease)}}
+	g := newFailoverGroup("failover-lease", DefaultFailurePolicy, &stubPicker{owner: owner, successor: successor}, &loads)
+	if view, source, err := g.GetWithSource("Tom"); err != nil || view.String() != "630" || source != SourceFallback {
+		t.Fatalf("should fall back to local load, got %v %v %v", view, source, err)
+	}
+	if successor.acquired != 1 || owner.acquired != 0 || loads != 1 {
+		t.Fatalf("lease should be granted by successor, successor=%d owner=%d loads=%d", successor.acquired, owner.acquired, loads)
+	}
+
+	// 下一个候选节点也已经发生传输错误时，不再向它申请租约
+	policy := DefaultFailurePolicy
+	policy.TrySuccessor = true
+	successor.err = down
+	g = newFailoverGroup("failover-lease-down", policy, &stubPicker{owner: owner, successor: successor}, &loads)
+	if _, err := g.Get("Tom"); err != nil || successor.acquired != 1 || loads != 2 {
+		t.Fatalf("should load directly, err=%v successor=%d loads=%d", err, successor.acquired, loads)
+	}
+}
@@ -5,19 +5,26 @@ import (
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
-	"log"
+	"io"
 	"net"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 	"tinycache/hash"
 	"tinycache/registry"
 	pb "tinycache/tinycachepb"
-)
-
-const (
-	defaultReplicasRPC = 50 //默认虚拟节点数量
+	"tinycache/topk"
 )
 
 //---------------------------------Server---------------------------------
@@ -39,45 +46,414 @@ type Server struct {
 	status                           bool       // 当前服务器的运行状态,true为运行中,false为已停止
 	stopSignal                       chan error // 用于接收停止信号,通知服务器停止运行，可有其他组件发出信号，比如说registry服务，通知当前服务器停止运行
 	mu                               sync.Mutex
-	peers                            *hash.Map          //一致性哈希，确定缓存数据在集群的哪个节点
+	peers                            hash.Placement     //节点放置算法（默认一致性哈希），确定缓存数据在集群的哪个节点
+	nodes                            map[string]int     // 集群中所有节点及其权重，节点变化时据此重建 peers
+	weights                          map[string]int     // 各节点在注册中心中声明的权重，由 watchWeights 更新
 	clients                          map[string]*Client // 存储其他节点的客户端连接，键是其他节点的地址，值是与该节点建立的客户端连接
+	opts                             options            // 可选配置
+	grpcServer                       *grpc.Server       // 正在运行的 gRPC 服务器，Shutdown 时优雅停止
+	health                           *health.Server     // 标准的 gRPC 健康检查服务
+	ready                            bool               // 是否就绪，例如预热完成
+	registered                       bool               // 是否已注册到etcd，Start 启动的节点只有注册后才算健康
+	rpcs                             inflightRPCs       // 正在处理的请求，Shutdown 时等待它们完成
+	handoffs                         handoffQueue       // 节点变化后的迁移，串行执行
 }
 
 // NewServer 创建一个新的Server实例
-func NewServer(self string) (*Server, error) {
-	return &Server{
+func NewServer(self string, opts ...Option) (*Server, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &Server{
 		self:    self,
-		peers:   hash.NewConsistentHash(defaultReplicasRPC, nil),
+		peers:   o.newPlacement(),
+		nodes:   map[string]int{},
+		weights: map[string]int{},
 		clients: map[string]*Client{},
-	}, nil
+		opts:    o,
+		health:  health.NewServer(),
+		ready:   !o.warmup,
+	}
+	s.updateHealth()
+	return s, nil
+}
+
+// SetReady 设置节点是否就绪，例如预热完成后调用 SetReady(true)
+func (s *Server) SetReady(ready bool) {
+	s.mu.Lock()
+	s.ready = ready
+	s.mu.Unlock()
+	s.updateHealth()
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式导出指标的 http.Handler，可以挂载到 API 服务器上，例如
+//
+//	http.Handle("/metrics", svr.MetricsHandler())
+func (s *Server) MetricsHandler() http.Handler {
+	return metricsHandler(func() nodeMetrics {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		n := nodeMetrics{node: s.self, ring: len(s.nodes), peers: map[string]*histogram{}, rpc: s.opts.rpcMetrics}
+		for addr, client := range s.clients {
+			if addr != s.self {
+				n.peers[addr] = &client.pg.hist
+			}
+		}
+		return n
+	})
+}
+
+// AdminHandler 返回管理接口的 http.Handler，需要通过 WithAdminToken 设置令牌，并挂载到 /admin/ 上，例如
+//
+//	http.Handle("/admin/", svr.AdminHandler())
+func (s *Server) AdminHandler() http.Handler {
+	return adminHandler(adminNode{
+		self:  s.self,
+		token: s.opts.adminToken,
+		peers: func() []string {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			peers := make([]string, 0, len(s.nodes))
+			for peer := range s.nodes {
+				peers = append(peers, peer)
+			}
+			return peers
+		},
+		owner: func(key string) string {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.peers.Get(key)
+		},
+	})
+}
+
+// setRegistered 由注册中心在注册成功和失效时调用
+func (s *Server) setRegistered(registered bool) {
+	s.mu.Lock()
+	s.registered = registered
+	s.mu.Unlock()
+	s.updateHealth()
+}
+
+// updateHealth 更新健康检查的状态：节点就绪，并且通过 Start 启动时已注册到etcd，才报告 SERVING
+func (s *Server) updateHealth() {
+	s.mu.Lock()
+	serving := s.ready && (s.registered || !s.status)
+	s.mu.Unlock()
+	state := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		state = healthpb.HealthCheckResponse_SERVING
+	}
+	s.health.SetServingStatus("", state)
+	s.health.SetServingStatus(pb.GroupCache_ServiceDesc.ServiceName, state)
 }
 
+// ProtocolVersion 是当前节点间通信的协议版本
+//   - 0：旧版本，gRPC Get 的 Response.Value 是序列化后的 Response（二次编码）
+//   - 1：Response 直接携带缓存值，和 HTTP 接口的响应格式相同
+//
+// 客户端在 Request.Protocol 中声明自己支持的版本，服务端按客户端能理解的格式响应，
+// 并在 Response.Protocol 中标明实际使用的版本，滚动升级期间新旧节点可以互相访问
+const ProtocolVersion uint32 = 1
+
 // Get 实现Server对gRPC客户端请求的处理和响应
-func (s *Server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+func (s *Server) Get(ctx context.Context, in *pb.Request) (res *pb.Response, err error) {
 	group, key := in.Group, in.Key
-	resp := &pb.Response{}
-	log.Printf("[TinyCache_svr %s] Recv RPC Request - (%s)/(%s)", s.self, group, key)
+	s.opts.log.request("recv rpc request", "node", s.self, "group", group, keyHash(key))
+	ctx, span := startSpan(incomingTraceContext(ctx), "tinycache.Server.Get")
+	span.SetAttribute("node", s.self)
+	defer func() { endSpan(span, err) }()
 
 	if key == "" {
-		return resp, fmt.Errorf("key is empty")
+		return &pb.Response{}, fmt.Errorf("key is empty")
 	}
 
 	g := GetGroup(group)
 	if g == nil {
-		return resp, fmt.Errorf("group %s not found", group)
+		return &pb.Response{}, fmt.Errorf("group %s not found", group)
+	}
+	if in.Hedged {
+		ctx = withHedged(ctx)
+	}
+	view, err := g.GetContext(ctx, key)
+	if err != nil {
+		return &pb.Response{}, err
+	}
+	resp := &pb.Response{
+		Value:    view.ByteSlice(),
+		Version:  view.Version(),
+		LoadTime: view.LoadTime().UnixNano(),
+		Protocol: ProtocolVersion,
+	}
+	if in.Protocol >= 1 {
+		return resp, nil
+	}
+	// 旧版本客户端：把响应序列化后放在外层 Response 的 Value 字段中
+	resp.Protocol = 0
+	body, err := proto.Marshal(resp)
+	if err != nil {
+		return &pb.Response{}, fmt.Errorf("encoding legacy response: %v", err)
+	}
+	return &pb.Response{Value: body}, nil
+}
+
+// streamChunkSize 是分段传输时每一段的大小
+const streamChunkSize = 64 << 10
+
+// GetStream 把缓存值按 streamChunkSize 分段发送，大对象不受单条 gRPC 消息大小的限制
+func (s *Server) GetStream(in *pb.Request, stream pb.GroupCache_GetStreamServer) error {
+	if in.Key == "" {
+		return fmt.Errorf("key is empty")
+	}
+	g := GetGroup(in.Group)
+	if g == nil {
+		return fmt.Errorf("group %s not found", in.Group)
+	}
+	ctx := stream.Context()
+	if in.Hedged {
+		ctx = withHedged(ctx)
 	}
-	view, err := g.Get(key)
+	view, err := g.GetContext(ctx, in.Key)
 	if err != nil {
-		return resp, err
+		return err
+	}
+	chunk := &pb.Chunk{
+		Version:  view.Version(),
+		LoadTime: view.LoadTime().UnixNano(),
+		Size:     int64(view.Len()),
+	}
+	r := view.Reader()
+	for first := true; ; first = false {
+		buf := make([]byte, streamChunkSize) // 发送后的消息不能再修改，每一段使用新的缓冲区
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || first { // 空值也要发送一段携带元数据
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			chunk = &pb.Chunk{}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Migrate 接收其他节点在节点变化时推送过来的缓存数据，写入对应 Group 的 mainCache
+func (s *Server) Migrate(stream pb.GroupCache_MigrateServer) error {
+	var accepted int64
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			s.opts.log.get().Info("received migrated entries", "node", s.self, "accepted", accepted)
+			return stream.SendAndClose(&pb.MigrateResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+		g := GetGroup(entry.Group)
+		if g == nil || entry.Key == "" {
+			continue
+		}
+		value := ByteView{b: cloneBytes(entry.Value), version: entry.Version, loadedAt: time.Now()}
+		if g.populateVersioned(entry.Key, value, false) {
+			accepted++
+		}
+	}
+}
+
+// Invalidate 处理其他节点广播的失效消息，删除本节点中key的副本
+func (s *Server) Invalidate(ctx context.Context, in *pb.InvalidateRequest) (*pb.InvalidateResponse, error) {
+	g := GetGroup(in.Group)
+	if g == nil {
+		return &pb.InvalidateResponse{}, fmt.Errorf("group %s not found", in.Group)
+	}
+	if err := g.applyRemoteInvalidation(in.Key, in.Seq); err != nil {
+		return &pb.InvalidateResponse{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &pb.InvalidateResponse{}, nil
+}
+
+// AcquireLease 为其他节点发放加载数据源的租约
+func (s *Server) AcquireLease(ctx context.Context, in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	return leases.acquire(in), nil
+}
+
+// ReleaseLease 归还其他节点持有的租约，并保存加载结果
+func (s *Server) ReleaseLease(ctx context.Context, in *pb.ReleaseRequest) (*pb.ReleaseResponse, error) {
+	leases.release(in)
+	return &pb.ReleaseResponse{}, nil
+}
+
+// rpcServer 是注册到 gRPC 的服务实现。Server.Set 已用于设置集群节点，
+// 所以 Set RPC 在这里实现，其余方法都来自 Server
+type rpcServer struct {
+	*Server
+}
+
+// Set 把key的值写入本节点：version 大于 0 时按 SetVersion 只写缓存，设置了 compare_and_set 时在本节点比较版本后写入，否则按 Set 写入
+func (s *rpcServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	g := GetGroup(in.Group)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "group %s not found", in.Group)
+	}
+	opt := WithTTL(time.Duration(in.TtlMs) * time.Millisecond)
+	switch {
+	case in.Version > 0:
+		applied, err := g.SetVersion(in.Key, in.Value, in.Version, opt)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.SetResponse{Version: in.Version, Applied: applied}, nil
+	case in.GetOptions().GetCompareAndSet():
+		// 发来请求的节点认为本节点是拥有者，直接在本节点比较，避免节点映射不一致时来回转发
+		version, applied, err := g.compareAndSetLocally(in.Key, in.Options.ExpectedVersion, in.Value, time.Duration(in.TtlMs)*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.SetResponse{Version: version, Applied: applied}, nil
 	}
-	// 将获取到的缓存数据序列化为 protobuf 格式，并存储在响应对象的 Value 字段中
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	version, err := g.Set(in.Key, in.Value, opt)
 	if err != nil {
-		log.Printf("[TinyCache_svr %s] Failed to marshal response value\n", s.self)
+		return nil, err
+	}
+	return &pb.SetResponse{Version: version, Applied: true}, nil
+}
+
+var _ pb.GroupCacheServer = (*rpcServer)(nil)
+
+// Delete 从数据源删除key，并在整个集群中失效它的缓存副本
+func (s *Server) Delete(ctx context.Context, in *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	g := GetGroup(in.Group)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "group %s not found", in.Group)
+	}
+	if err := g.Delete(in.Key); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteResponse{}, nil
+}
+
+// getMultiConcurrency 是 GetMulti 同时获取的最大key数
+const getMultiConcurrency = 16
+
+// GetMulti 批量获取多个key，单个key失败不影响其他key，错误记录在对应结果的 error 字段中。
+// 最多同时获取 getMultiConcurrency 个key，请求取消后不再获取剩下的key
+func (s *Server) GetMulti(ctx context.Context, in *pb.GetMultiRequest) (*pb.GetMultiResponse, error) {
+	g := GetGroup(in.Group)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "group %s not found", in.Group)
+	}
+	values := make([]*pb.KeyValue, len(in.Keys))
+	sem := make(chan struct{}, getMultiConcurrency)
+	var wg sync.WaitGroup
+	for i, key := range in.Keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			wg.Wait()
+			return nil, status.FromContextError(err).Err() // 请求已取消，不再获取剩下的key
+		}
+		wg.Add(1)
+		go func(i int, key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			kv := &pb.KeyValue{Key: key}
+			if view, err := g.GetContext(ctx, key); err != nil {
+				kv.Error = err.Error()
+			} else {
+				kv.Value = view.ByteSlice()
+				kv.Version = view.Version()
+				kv.LoadTime = view.LoadTime().UnixNano()
+			}
+			values[i] = kv
+		}(i, key)
+	}
+	wg.Wait()
+	return &pb.GetMultiResponse{Values: values}, nil
+}
+
+// Stats 返回本节点上 Group 的统计信息
+func (s *Server) Stats(ctx context.Context, in *pb.StatsRequest) (*pb.StatsResponse, error) {
+	var list []*Group
+	if in.Group != "" {
+		g := GetGroup(in.Group)
+		if g == nil {
+			return nil, status.Errorf(codes.NotFound, "group %s not found", in.Group)
+		}
+		list = append(list, g)
+	} else {
+		list = allGroups()
+	}
+	res := &pb.StatsResponse{}
+	for _, g := range list {
+		stats := g.Stats()
+		res.Groups = append(res.Groups, &pb.GroupStats{
+			Name:            stats.Name,
+			MainCache:       cacheStatsProto(stats.MainCache),
+			HotCache:        cacheStatsProto(stats.HotCache),
+			Gets:            stats.Gets,
+			HotHits:         stats.HotHits,
+			MainHits:        stats.MainHits,
+			PeerLoads:       stats.PeerLoads,
+			PeerErrors:      stats.PeerErrors,
+			LocalLoads:      stats.LocalLoads,
+			LocalLoadErrors: stats.LocalLoadErrors,
+			LoadsDeduped:    stats.LoadsDeduped,
+		})
+	}
+	sort.Slice(res.Groups, func(i, j int) bool { return res.Groups[i].Name < res.Groups[j].Name })
+	return res, nil
+}
+
+func cacheStatsProto(s CacheStats) *pb.CacheStats {
+	return &pb.CacheStats{Items: s.Items, Bytes: s.Bytes, Evictions: s.Evictions, Expirations: s.Expirations}
+}
+
+// ListKeys 按字典序分页列出本节点 mainCache 中的key
+func (s *Server) ListKeys(ctx context.Context, in *pb.ListKeysRequest) (*pb.ListKeysResponse, error) {
+	g := GetGroup(in.Group)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "group %s not found", in.Group)
+	}
+	keys := g.Keys(in.Prefix)
+	if in.StartAfter != "" {
+		keys = keys[sort.SearchStrings(keys, in.StartAfter):]
+		if len(keys) > 0 && keys[0] == in.StartAfter {
+			keys = keys[1:]
+		}
+	}
+	res := &pb.ListKeysResponse{Keys: keys}
+	if in.Limit > 0 && len(keys) > int(in.Limit) {
+		res.Keys, res.Truncated = keys[:in.Limit], true
+	}
+	return res, nil
+}
+
+// HotKeys 返回本节点上 Group 的热点 key
+func (s *Server) HotKeys(ctx context.Context, in *pb.HotKeysRequest) (*pb.HotKeysResponse, error) {
+	g := GetGroup(in.Group)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "group %s not found", in.Group)
 	}
-	resp.Value = body
+	hot := g.HotKeys(int(in.Limit))
+	return &pb.HotKeysResponse{Gets: keyCountsProto(hot.Gets), Misses: keyCountsProto(hot.Misses), Bytes: keyCountsProto(hot.Bytes)}, nil
+}
 
-	return resp, nil
+func keyCountsProto(counters []topk.Counter) []*pb.KeyCount {
+	res := make([]*pb.KeyCount, len(counters))
+	for i, c := range counters {
+		res[i] = &pb.KeyCount{Key: c.Key, Count: c.Count, Error: c.Error}
+	}
+	return res
 }
 
 // Start 启动缓存服务
@@ -96,26 +472,44 @@ func (s *Server) Start() error {
 		s.mu.Unlock()
 		return fmt.Errorf("server %s is already running", s.self)
 	}
+	if s.grpcServer != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("server %s is already running", s.self)
+	}
 	s.status = true
-	s.stopSignal = make(chan error)
+	s.stopSignal = make(chan error, 1) // 带缓冲，注册失败时 Stop 也不会阻塞
 
 	port := strings.Split(s.self, ":")[1]
-	lis, err := net.Listen("tcp", port) // 监听指定tcp端口，用于接收客户端的gRPC请求
+	lis, err := net.Listen("tcp", ":"+port) // 监听指定tcp端口，用于接收客户端的gRPC请求
 	if err != nil {
+		s.status = false
+		s.mu.Unlock()
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 
-	// 创建一个新的 gRPC 服务器 grpcServer，然后将当前的 Server 对象 s 注册为 gRPC 服务。
-	// 这样，gRPC 服务器就能够处理来自客户端的请求。
-	grpcServer := grpc.NewServer()
-	pb.RegisterGroupCacheServer(grpcServer, s)
+	grpcServer := s.newGRPCServer()
+	s.grpcServer = grpcServer
 
 	go func() {
-		// 注册服务到etcd
-		// 当停止信号被接收后，关闭通知通道 s.stopSignal，关闭 TCP 监听端口，并输出日志表示服务已经停止。
+		// 服务运行期间监听其他节点在etcd中声明的权重，权重变化时自动重建节点映射
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go s.watchWeights(ctx, watchEtcdWeights)
+		// 注册服务到etcd，同时把节点权重写入元数据。Register 会一直阻塞直到收到停止信号
+		// 当停止信号被接收后，关闭 TCP 监听端口，并输出日志表示服务已经停止。
+		service := fmt.Sprintf("geecache/%s", s.self)
+		err := registry.RegisterNotify(service, s.self, registry.Metadata{Weight: s.opts.weight}, s.stopSignal, s.setRegistered)
+		if err != nil {
+			s.opts.log.get().Error("register service failed", "node", s.self, "err", err)
+			return
+		}
+		lis.Close()
+		s.opts.log.get().Info("revoked service and closed listener", "node", s.self)
 	}()
 
 	s.mu.Unlock()
+	s.health.Resume() // 之前 Shutdown 过的节点重新启动
+	s.updateHealth()  // 注册到etcd之前报告 NOT_SERVING
 
 	//启动 gRPC 服务器。grpcServer.Serve(lis) 会阻塞，处理客户端的 gRPC 请求，直到服务器关闭或发生错误。
 	//如果服务器状态为运行状态（s.status 为 true），并且发生了错误，则返回相应的错误。
@@ -125,88 +519,693 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Set 方法用于设置其他缓存节点的地址信息，并为每个节点创建相应的客户端连接
-func (s *Server) Set(peersAddr ...string) {
+// Serve 在 lis 上提供gRPC服务，直到 lis 被关闭。它不会把节点注册到etcd，
+// 适用于配合 WithDirectDial 的静态集群或测试
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.grpcServer != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("server %s is already running", s.self)
+	}
+	grpcServer := s.newGRPCServer()
+	s.grpcServer = grpcServer
+	s.mu.Unlock()
+	s.health.Resume()
+	s.updateHealth()
+	return grpcServer.Serve(lis)
+}
+
+// newGRPCServer 创建一个新的 gRPC 服务器，然后将当前的 Server 对象 s 注册为 gRPC 服务。
+// 这样，gRPC 服务器就能够处理来自客户端的请求。
+func (s *Server) newGRPCServer() *grpc.Server {
+	s.rpcs.reset()
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{s.rpcs.unaryInterceptor}, s.opts.unaryServer...)...),
+		grpc.ChainStreamInterceptor(append([]grpc.StreamServerInterceptor{s.rpcs.streamInterceptor}, s.opts.streamServer...)...),
+		grpc.StatsHandler(&s.rpcs),
+	}
+	if s.opts.tls != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.opts.tls.serverConfig(s.isPeerHost))))
+	}
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterGroupCacheServer(grpcServer, &rpcServer{Server: s})
+	healthpb.RegisterHealthServer(grpcServer, s.health)
+	reflection.Register(grpcServer)
+	return grpcServer
+}
+
+// isPeerHost 判断 host 是否是集群中某个节点的主机地址，用于校验客户端证书
+func (s *Server) isPeerHost(host string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.peers.Add(peersAddr...)            //将传入的所有节点地址批量添加到一致性哈希映射 s.peers 中
-	for _, peerAddr := range peersAddr { //遍历传入的节点地址列表 peersAddr，为每个节点创建一个客户端连接
+	if peerHost(s.self) == host {
+		return true
+	}
+	for node := range s.nodes {
+		if peerHost(node) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Set 方法用于设置其他缓存节点的地址信息，并为每个节点创建相应的客户端连接。
+// 节点的权重取它在注册中心中声明的权重（见 watchWeights），还不知道时为 1。
+// 节点变化后，本节点不再拥有的缓存数据会被迁移给新的拥有者
+func (s *Server) Set(peersAddr ...string) {
+	s.mu.Lock()
+	peers := make(map[string]int, len(peersAddr))
+	for _, peerAddr := range peersAddr {
+		peers[peerAddr] = 1
+		if weight, ok := s.weights[peerAddr]; ok {
+			peers[peerAddr] = weight
+		}
+	}
+	old, cur := s.setWeightedLocked(peers)
+	s.mu.Unlock()
+	s.scheduleHandoff(old, cur)
+}
+
+// SetWeighted 方法同 Set，按权重添加其他缓存节点，权重越大的节点在一致性哈希环上的虚拟节点越多
+func (s *Server) SetWeighted(peers map[string]int) {
+	s.mu.Lock()
+	old, cur := s.setWeightedLocked(peers)
+	s.mu.Unlock()
+	s.scheduleHandoff(old, cur)
+}
+
+// setWeightedLocked 添加节点并重建节点映射，返回变化前后的映射，调用方需持有 s.mu
+func (s *Server) setWeightedLocked(peers map[string]int) (old, cur hash.Placement) {
+	for peerAddr, weight := range peers { //遍历传入的节点地址，为每个节点创建一个客户端连接
+		s.nodes[peerAddr] = weight
 		service := fmt.Sprintf("geecache/%s", peerAddr) //客户端的服务名（service）由节点地址构成，并且遵循一定的命名规则（在这里是 geecache/<peerAddr>）。
-		s.clients[peerAddr] = NewClient(service)        //然后，使用 NewClient(service) 函数创建一个新的客户端连接，并将连接对象存储在 s.clients 映射中，以便后续通过节点地址进行查找和通信
+		if _, ok := s.clients[peerAddr]; !ok {          // 已有的客户端保留下来，熔断器和延迟统计不会因为节点变化而重置
+			s.clients[peerAddr] = newClient(service, s.opts) //然后，使用 newClient 函数创建一个新的客户端连接，并将连接对象存储在 s.clients 映射中，以便后续通过节点地址进行查找和通信
+		}
+	}
+	return s.rebuildPeersLocked()
+}
+
+// Remove 方法从集群中移除节点，节点变化后本节点不再拥有的缓存数据会被迁移给新的拥有者
+func (s *Server) Remove(peersAddr ...string) {
+	s.mu.Lock()
+	for _, peerAddr := range peersAddr {
+		delete(s.nodes, peerAddr)
+		delete(s.clients, peerAddr)
+	}
+	old, cur := s.rebuildPeersLocked()
+	s.mu.Unlock()
+	s.scheduleHandoff(old, cur)
+}
+
+// rebuildPeersLocked 按 s.nodes 重建节点映射，返回变化前后的映射。调用方需持有 s.mu
+func (s *Server) rebuildPeersLocked() (old, cur hash.Placement) {
+	old, s.peers = s.peers, s.buildPeers()
+	return old, s.peers
+}
+
+// buildPeers 根据 s.nodes 重新创建节点放置算法的实例。节点按名称顺序添加，
+// 保证集群中每个节点构建出的映射完全一致（例如 Jump 依赖节点的添加顺序）。调用方需持有 s.mu
+func (s *Server) buildPeers() hash.Placement {
+	nodes := make([]string, 0, len(s.nodes))
+	for node := range s.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	peers := s.opts.newPlacement()
+	for _, node := range nodes {
+		peers.AddWeighted(node, s.nodes[node])
+	}
+	return peers
+}
+
+// weightWatcher 监听注册中心中各节点声明的权重，每当节点注册或更新权重时调用 fn，直到 ctx 结束或监听中断
+type weightWatcher func(ctx context.Context, fn func(addr string, weight int)) error
+
+// watchEtcdWeights 从etcd监听所有 geecache 节点注册时携带的权重
+func watchEtcdWeights(ctx context.Context, fn func(addr string, weight int)) error {
+	cli, err := clientv3.New(defaultEtcdConfig)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	return registry.WatchMetadata(ctx, cli, "geecache", func(addr string, md registry.Metadata) {
+		fn(addr, md.Weight)
+	})
+}
+
+// watchWeights 持续监听各节点声明的权重，已知节点的权重变化时自动重建节点映射，直到 ctx 结束。
+// 监听中断后等待一秒重新监听
+func (s *Server) watchWeights(ctx context.Context, watch weightWatcher) {
+	for {
+		err := watch(ctx, s.applyWeight)
+		if ctx.Err() != nil {
+			return
+		}
+		s.opts.log.get().Warn("watch peer weights failed", "node", s.self, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// applyWeight 记录节点 addr 声明的权重，addr 已在集群中且权重变化时重建节点映射并迁移数据
+func (s *Server) applyWeight(addr string, weight int) {
+	s.mu.Lock()
+	s.weights[addr] = weight
+	if cur, ok := s.nodes[addr]; !ok || cur == weight {
+		s.mu.Unlock()
+		return
 	}
+	s.nodes[addr] = weight
+	old, cur := s.rebuildPeersLocked()
+	s.mu.Unlock()
+	s.opts.log.get().Info("peer weight changed", "node", s.self, "peer", addr, "weight", weight)
+	s.scheduleHandoff(old, cur)
+}
+
+// SyncWeights 从etcd读取每个已知节点注册时声明的权重，并据此重建一致性哈希中的虚拟节点。
+// 通过 Start 启动的节点会自动监听权重的变化，一般不需要手动调用
+func (s *Server) SyncWeights() error {
+	cli, err := clientv3.New(defaultEtcdConfig)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	s.mu.Lock()
+	peersAddr := make([]string, 0, len(s.clients))
+	for peerAddr := range s.clients {
+		peersAddr = append(peersAddr, peerAddr)
+	}
+	s.mu.Unlock()
+
+	weights := make(map[string]int, len(peersAddr))
+	for _, peerAddr := range peersAddr {
+		md, err := registry.EtcdMetadata(cli, fmt.Sprintf("geecache/%s", peerAddr))
+		if err != nil {
+			return fmt.Errorf("read metadata of %s failed: %v", peerAddr, err)
+		}
+		weights[peerAddr] = md.Weight
+	}
+	s.SetWeighted(weights)
+	return nil
 }
 
 // PickPeer 方法，用于根据给定的键选择相应的对等节点
 func (s *Server) PickPeer(key string) (PeerGetter, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	peerAddr := s.peers.Get(key) //根据给定的键 key 选择相应的对等节点的地址 peerAddr
-	if peerAddr == s.self {      //如果选择的节点地址与当前服务器的地址相同，说明该节点就是当前服务器本身
-		log.Printf("ooh! pick myself, I am %s\n", s.self)
+	peerAddr := s.peers.Get(key)              //根据给定的键 key 选择相应的对等节点的地址 peerAddr
+	if peerAddr == "" || peerAddr == s.self { //如果没有节点，或选择的节点地址与当前服务器的地址相同，说明该节点就是当前服务器本身
+		s.opts.log.request("pick self", "node", s.self, keyHash(key))
+		return nil, false
+	}
+	s.opts.log.request("pick peer", "node", s.self, keyHash(key), "peer", peerAddr)
+	return newHedgedPeer(s.clients[peerAddr], s.replicaLocked(key), s.opts.hedge), true //如果选择的节点不是当前服务器本身，日志会记录当前服务器选择了远程对等节点，并且函数会返回选择的对等节点的客户端连接（s.clients[peerAddr]）和 true，表示选择成功
+}
+
+// replicaLocked 返回用于对冲请求的下一个候选节点，没有或是自己时返回 nil。调用方需持有 s.mu
+func (s *Server) replicaLocked(key string) PeerGetter {
+	if s.opts.hedge == nil {
+		return nil
+	}
+	candidates := s.peers.GetN(key, 2)
+	if len(candidates) < 2 || candidates[1] == s.self {
+		return nil
+	}
+	return s.clients[candidates[1]]
+}
+
+// ListPeers 返回除自身外所有远程节点的客户端
+func (s *Server) ListPeers() []PeerGetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(s.clients))
+	for peerAddr, client := range s.clients {
+		if peerAddr != s.self {
+			peers = append(peers, client)
+		}
+	}
+	return peers
+}
+
+// PickSuccessor 返回放置算法给出的第二个候选节点，用于拥有者不可用时重试
+func (s *Server) PickSuccessor(key string) (PeerGetter, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	candidates := s.peers.GetN(key, 2)
+	if len(candidates) < 2 || candidates[1] == s.self {
 		return nil, false
 	}
-	log.Printf("[cache %s] pick remote peer: %s\n", s.self, peerAddr)
-	return s.clients[peerAddr], true //如果选择的节点不是当前服务器本身，日志会记录当前服务器选择了远程对等节点，并且函数会返回选择的对等节点的客户端连接（s.clients[peerAddr]）和 true，表示选择成功
+	return s.clients[candidates[1]], true
 }
 
 // Stop 停止server运行 如果server没有运行 这将是一个no-op
+// 等同于 Shutdown(context.Background())，会一直等到正在处理的请求全部完成
 func (s *Server) Stop() {
+	s.Shutdown(context.Background())
+}
+
+// Shutdown 优雅地停止server：
+//  1. 把本节点拥有的缓存数据迁移给去掉本节点后的新拥有者，避免缓存随节点下线而丢失
+//  2. 写回所有 Group 还在回写队列中的写操作
+//  3. 从etcd注销，拒绝新的请求，等待正在处理的请求完成后关闭服务器
+//
+// 每一步都受 ctx 控制：ctx 结束时跳过剩下的迁移和写回，不再等待正在处理的请求，立即关闭所有连接并返回 ctx.Err()。
+// 如果server没有运行 这将是一个no-op
+func (s *Server) Shutdown(ctx context.Context) error {
 	s.mu.Lock()
-	if s.status == false {
+	grpcServer := s.grpcServer
+	if grpcServer == nil && !s.status {
 		s.mu.Unlock()
-		return
+		return nil
+	}
+	delete(s.nodes, s.self)
+	old, cur := s.peers, s.buildPeers()
+	s.mu.Unlock()
+	s.health.Shutdown() // 健康检查立即返回 NOT_SERVING，负载均衡不再把请求发给本节点
+	if base := s.stopHandoffs(); base != nil {
+		old = base // 节点变化触发的迁移已经过时，没有迁移完的数据一起按下线后的映射迁移
+	}
+	s.handoff(ctx, old, cur) // 同步迁移，迁移完成后再停止
+	for _, g := range allGroups() {
+		g.flushWrites(ctx) // 写回还在队列中的写操作
 	}
-	s.stopSignal <- nil // 发送停止keepalive信号
-	s.status = false    // 设置server运行状态为stop
-	s.clients = nil     // 清空一致性哈希信息 有助于垃圾回收
-	s.peers = nil       // 清空一致性哈希映射
+
+	s.mu.Lock()
+	if s.status {
+		s.stopSignal <- nil // 发送停止keepalive信号
+		s.status = false    // 设置server运行状态为stop
+	}
+	s.grpcServer = nil
+	s.clients = map[string]*Client{} // 清空其他节点的客户端 有助于垃圾回收
+	s.nodes = map[string]int{}
+	s.peers = s.opts.newPlacement() // 清空节点映射，之后所有key都由本节点处理
 	s.mu.Unlock()
+
+	if grpcServer == nil {
+		return ctx.Err()
+	}
+	// 先自己等待正在处理的请求完成，再调用 GracefulStop 发送剩下的响应并关闭连接。
+	// 不直接等待 GracefulStop：它在等待处理函数返回时持有 grpc.Server 的锁，超时后调用的 Stop 也要等到处理函数返回
+	select {
+	case <-s.rpcs.drain():
+	case <-ctx.Done():
+		grpcServer.Stop() // 强制关闭所有连接，不等待处理函数返回
+		return ctx.Err()
+	}
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop() // 已经没有正在处理的请求，等待响应发送完毕和连接关闭
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		grpcServer.Stop()
+		return ctx.Err()
+	}
+}
+
+// inflightRPCs 记录正在处理的 GroupCache 请求，Shutdown 时拒绝新的请求并等待已有的请求完成。
+// 它作为 stats.Handler 计数，请求在响应状态写出之后才算完成
+type inflightRPCs struct {
+	mu       sync.Mutex
+	n        int
+	draining bool
+	idle     chan struct{} // draining 且没有正在处理的请求时关闭
+}
+
+// groupCacheMethod 是 GroupCache 服务方法名的前缀，健康检查和反射服务的长连接请求不计入 inflightRPCs
+const groupCacheMethod = "/tinycachepb.GroupCache/"
+
+type inflightKey struct{}
+
+func (r *inflightRPCs) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, inflightKey{}, strings.HasPrefix(info.FullMethodName, groupCacheMethod))
 }
 
-// 测试 Server 是否实现了 PeerPicker 接口
-var _ PeerPicker = (*Server)(nil)
+func (r *inflightRPCs) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	if tracked, _ := ctx.Value(inflightKey{}).(bool); !tracked {
+		return
+	}
+	switch rs.(type) {
+	case *stats.Begin:
+		r.mu.Lock()
+		r.n++
+		r.mu.Unlock()
+	case *stats.End:
+		r.mu.Lock()
+		r.n--
+		if r.draining && r.n == 0 {
+			close(r.idle)
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *inflightRPCs) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *inflightRPCs) HandleConn(ctx context.Context, cs stats.ConnStats) {}
+
+// drain 拒绝之后的请求，返回的 channel 在已有的请求全部完成时关闭
+func (r *inflightRPCs) drain() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.draining {
+		r.draining = true
+		r.idle = make(chan struct{})
+		if r.n == 0 {
+			close(r.idle)
+		}
+	}
+	return r.idle
+}
+
+// reset 重新开始接受请求，用于关闭后重新启动的 Server
+func (r *inflightRPCs) reset() {
+	r.mu.Lock()
+	r.draining = false
+	r.mu.Unlock()
+}
+
+func (r *inflightRPCs) rejecting() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+func (r *inflightRPCs) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if strings.HasPrefix(info.FullMethod, groupCacheMethod) && r.rejecting() {
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	return handler(ctx, req)
+}
+
+func (r *inflightRPCs) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if strings.HasPrefix(info.FullMethod, groupCacheMethod) && r.rejecting() {
+		return status.Error(codes.Unavailable, "server is shutting down")
+	}
+	return handler(srv, ss)
+}
+
+// 测试 Server 是否实现了 PeerPicker、PeerLister 和 SuccessorPicker 接口
+var (
+	_ PeerPicker      = (*Server)(nil)
+	_ PeerLister      = (*Server)(nil)
+	_ SuccessorPicker = (*Server)(nil)
+)
 
 //---------------------------------Client---------------------------------
 
 // Client 模块实现tinyCache访问其他远程节点,从而获取缓存的能力
 type Client struct {
-	baseURL string // 服务名称 tinycache/ip:addr
+	baseURL  string            // 服务名称 tinycache/ip:addr
+	addr     string            // 节点地址 ip:addr，直接拨号时使用
+	direct   bool              // 是否直接拨号，不经过etcd服务发现
+	dialOpts []grpc.DialOption // 建立连接时的额外选项，例如拦截器
+	timeout  time.Duration     // 每次请求的超时时间
+	pg       *peerGuard        // 熔断器、重试策略和延迟统计
 }
 
 // Get 方法允许 Client 结构体实例向远程节点发送请求，获取缓存数据，并将响应解码为 pb.Response 结构体。
+// 传输错误按重试策略重试，熔断器打开时直接返回传输错误
 func (g *Client) Get(in *pb.Request, out *pb.Response) error {
-	cli, err := clientv3.New(defaultEtcdConfig) // 创建一个etcd客户端
+	return g.GetContext(context.Background(), in, out)
+}
+
+// GetContext 同 Get，ctx 中的追踪上下文通过 gRPC metadata 传给远程节点
+func (g *Client) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return g.pg.do(ctx, func() error { return g.get(ctx, in, out) })
+}
+
+func (g *Client) get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	conn, closeConn, err := g.connect()
 	if err != nil {
-		return err
+		return &transportError{err: err}
 	}
-	defer cli.Close()
+	defer closeConn()
 
-	conn, err := registry.EtcdDial(cli, g.baseURL) //使用etcd客户端发现指定服务（g.baseURL）并建立连接（conn）。如果发现服务或建立连接失败，则返回错误。
+	grpcClient := pb.NewGroupCacheClient(conn)                               //创建一个 gRPC 客户端，用于向远程对等节点发送请求
+	ctx, cancel := context.WithTimeout(outgoingTraceContext(ctx), g.timeout) //创建一个带有超时时间（默认10秒）的上下文，并使用该上下文发送 gRPC 请求到远程节点
+	defer cancel()
+	req := &pb.Request{Group: in.GetGroup(), Key: in.GetKey(), Protocol: ProtocolVersion, Hedged: in.GetHedged()}
+	response, err := grpcClient.Get(ctx, req)
+	if err != nil {
+		return classifyRPCError(fmt.Errorf("reading response body:%w", err))
+	}
+	return decodeResponse(response, out)
+}
+
+// decodeResponse 按服务端标明的协议版本解码 gRPC Get 的响应
+func decodeResponse(response, out *pb.Response) error {
+	if response.GetProtocol() >= 1 {
+		proto.Merge(out, response)
+		return nil
+	}
+	// 旧版本服务端返回的是二次编码的响应
+	if err := proto.Unmarshal(response.GetValue(), out); err != nil {
+		return fmt.Errorf("decoding response body:%v", err)
+	}
+	return nil
+}
+
+// GetStream 方法分段接收远程节点的缓存值并写入 w，返回的 Response 只携带元数据
+func (g *Client) GetStream(in *pb.Request, w io.Writer) (*pb.Response, error) {
+	return g.GetStreamContext(context.Background(), in, w)
+}
+
+// GetStreamContext 同 GetStream，ctx 的截止时间、取消和追踪上下文会传给远程节点。
+// 传输错误按重试策略重试，已经向 w 写出部分数据后不再重试
+func (g *Client) GetStreamContext(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error) {
+	return g.pg.stream(ctx, w, func(w io.Writer) (*pb.Response, error) { return g.getStream(ctx, in, w) })
+}
+
+func (g *Client) getStream(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error) {
+	conn, closeConn, err := g.connect()
+	if err != nil {
+		return nil, &transportError{err: err}
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(outgoingTraceContext(ctx), g.timeout)
+	defer cancel()
+	req := &pb.Request{Group: in.GetGroup(), Key: in.GetKey(), Protocol: ProtocolVersion, Hedged: in.GetHedged()}
+	stream, err := pb.NewGroupCacheClient(conn).GetStream(ctx, req)
+	if err != nil {
+		return nil, classifyRPCError(fmt.Errorf("open stream:%w", err))
+	}
+	var res *pb.Response
+	var size, received int64
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, classifyRPCError(fmt.Errorf("reading stream:%w", err))
+		}
+		if res == nil { // 第一段携带元数据
+			res = &pb.Response{Version: chunk.Version, LoadTime: chunk.LoadTime, Protocol: ProtocolVersion}
+			size = chunk.Size
+		}
+		n, err := w.Write(chunk.Data)
+		received += int64(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if res == nil || received != size {
+		return nil, &transportError{err: fmt.Errorf("stream truncated: got %d of %d bytes", received, size)}
+	}
+	return res, nil
+}
+
+// Migrate 方法通过客户端流把缓存数据推送给远程节点，返回远程节点接收的条数
+func (g *Client) Migrate(entries []*pb.Entry) (int64, error) {
+	return g.MigrateContext(context.Background(), entries)
+}
+
+// MigrateContext 同 Migrate，ctx 结束时取消推送
+func (g *Client) MigrateContext(ctx context.Context, entries []*pb.Entry) (int64, error) {
+	conn, closeConn, err := g.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+	stream, err := pb.NewGroupCacheClient(conn).Migrate(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("open migrate stream:%v", err)
+	}
+	for _, entry := range entries {
+		if err = stream.Send(entry); err != nil {
+			return 0, fmt.Errorf("send migrate entry:%v", err)
+		}
+	}
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, fmt.Errorf("reading migrate response:%v", err)
+	}
+	return response.GetAccepted(), nil
+}
+
+// Invalidate 方法通知远程节点删除某个key的缓存副本
+func (g *Client) Invalidate(in *pb.InvalidateRequest) error {
+	conn, closeConn, err := g.connect()
 	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	defer closeConn()
 
-	grpcClient := pb.NewGroupCacheClient(conn)                               //创建一个 gRPC 客户端，用于向远程对等节点发送请求
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) //创建一个带有10秒超时时间的上下文，并使用该上下文发送 gRPC 请求到远程节点
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
 	defer cancel()
-	response, err := grpcClient.Get(ctx, in)
+	if _, err = pb.NewGroupCacheClient(conn).Invalidate(ctx, in); err != nil {
+		return fmt.Errorf("invalidate %s/%s:%v", in.GetGroup(), in.GetKey(), err)
+	}
+	return nil
+}
+
+// Set 方法在远程节点上写入key的值
+func (g *Client) Set(in *pb.SetRequest) (*pb.SetResponse, error) {
+	conn, closeConn, err := g.connect()
 	if err != nil {
-		return fmt.Errorf("reading response body:%v", err)
+		return nil, err
 	}
-	if err = proto.Unmarshal(response.GetValue(), out); err != nil {
-		return fmt.Errorf("decoding response body:%v", err)
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	res, err := pb.NewGroupCacheClient(conn).Set(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("set %s/%s:%v", in.GetGroup(), in.GetKey(), err)
+	}
+	return res, nil
+}
+
+// AcquireLease 方法向远程节点申请加载数据源的租约
+func (g *Client) AcquireLease(in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	conn, closeConn, err := g.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	res, err := pb.NewGroupCacheClient(conn).AcquireLease(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("acquire lease %s/%s:%v", in.GetGroup(), in.GetKey(), err)
+	}
+	return res, nil
+}
+
+// ReleaseLease 方法向远程节点归还租约
+func (g *Client) ReleaseLease(in *pb.ReleaseRequest) error {
+	conn, closeConn, err := g.connect()
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	if _, err = pb.NewGroupCacheClient(conn).ReleaseLease(ctx, in); err != nil {
+		return fmt.Errorf("release lease %s/%s:%v", in.GetGroup(), in.GetKey(), err)
 	}
 	return nil
 }
 
+// isMessageTooLarge 判断 err 是否因为缓存值超过了单条 gRPC 消息的大小限制而失败，这时可以改用分段传输
+func isMessageTooLarge(err error) bool {
+	return status.Code(err) == codes.ResourceExhausted
+}
+
+// classifyRPCError 按 gRPC 状态码区分传输层错误和远程节点返回的业务错误。
+// 消息超过大小限制（ResourceExhausted）说明节点可用，不计为传输错误
+func classifyRPCError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Canceled:
+		return &transportError{err: err}
+	}
+	return err
+}
+
+// connect 通过etcd发现远程节点并建立连接，返回的 closeConn 用于释放连接和etcd客户端
+func (g *Client) connect() (conn *grpc.ClientConn, closeConn func(), err error) {
+	if g.direct {
+		opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, g.dialOpts...)
+		conn, err = grpc.Dial(g.addr, opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, func() { conn.Close() }, nil
+	}
+
+	cli, err := clientv3.New(defaultEtcdConfig) // 创建一个etcd客户端
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err = registry.EtcdDial(cli, g.baseURL, g.dialOpts...) //使用etcd客户端发现指定服务（g.baseURL）并建立连接（conn）。如果发现服务或建立连接失败，则返回错误。
+	if err != nil {
+		cli.Close()
+		return nil, nil, err
+	}
+	return conn, func() {
+		conn.Close()
+		cli.Close()
+	}, nil
+}
+
 // NewClient 创建一个远程节点客户端
 func NewClient(service string) *Client {
-	return &Client{baseURL: service}
+	return newClient(service, defaultOptions())
 }
 
-// 测试 Client 是否实现了 PeerGetter 接口
-var _ PeerGetter = (*Client)(nil)
+// newClient 按节点的可选配置创建远程节点客户端
+func newClient(service string, o options) *Client {
+	addr := strings.TrimPrefix(service, "geecache/")
+	dialOpts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(o.unaryClient...),
+		grpc.WithChainStreamInterceptor(o.streamClient...),
+	}
+	if o.tls != nil {
+		// 服务端证书必须包含注册的节点地址
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(o.tls.clientConfig(peerHost(addr)))))
+	}
+	return &Client{
+		baseURL:  service,
+		addr:     addr,
+		direct:   o.direct,
+		dialOpts: dialOpts,
+		timeout:  o.timeout,
+		pg:       newPeerGuard(o),
+	}
+}
+
+func (g *Client) guard() *peerGuard { return g.pg }
+
+// 测试 Client 是否实现了 PeerGetter、PeerInvalidator 和 PeerLeaser 接口
+var (
+	_ PeerGetter      = (*Client)(nil)
+	_ PeerInvalidator = (*Client)(nil)
+	_ PeerLeaser      = (*Client)(nil)
+	_ PeerSetter      = (*Client)(nil)
+	_ PeerStreamer    = (*Client)(nil)
+	_ guarded         = (*Client)(nil)
+
+	_ PeerContextGetter   = (*Client)(nil)
+	_ PeerContextStreamer = (*Client)(nil)
+)
 
 /*
 如何理解这个Server和Client。
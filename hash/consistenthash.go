@@ -2,6 +2,7 @@ package hash
 
 import (
 	"hash/crc32"
+	"hash/fnv"
 	"sort"
 	"strconv"
 )
@@ -9,67 +10,160 @@ import (
 // Hash 定义了函数类型，采取依赖注入的方式，允许用于替换成自定义的 Hash 函数，也方便测试时替换，默认为 crc32.ChecksumIEEE 算法。
 type Hash func(data []byte) uint32
 
+// Hash64 是64位的哈希函数，节点和虚拟节点较多时可以显著降低虚拟节点之间的哈希冲突，默认为 FNV-1a 算法。
+type Hash64 func(data []byte) uint64
+
 type Map struct {
-	hash     Hash           //一致性哈希的哈希算法
-	replicas int            //虚拟节点倍数
-	keys     []int          //哈希环
-	hashmap  map[int]string //虚拟节点和真实节点的映射关系
+	hash     Hash64              //一致性哈希的哈希算法，32位的 Hash 会被转换为64位
+	replicas int                 //虚拟节点倍数
+	keys     []uint64            //哈希环，每个哈希值只出现一次
+	hashmap  map[uint64][]string //虚拟节点和真实节点的映射关系，发生冲突时一个哈希值对应多个真实节点，按名称排序，第一个为实际拥有者
+	weights  map[string]int      //真实节点和权重的映射关系，节点的虚拟节点数为 replicas * weight
 }
 
 func NewConsistentHash(replicas int, hash Hash) *Map {
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return NewConsistentHash64(replicas, func(data []byte) uint64 {
+		return uint64(hash(data))
+	})
+}
+
+// NewConsistentHash64 创建一个使用64位哈希的一致性哈希环，hash 为 nil 时使用 FNV-1a
+func NewConsistentHash64(replicas int, hash Hash64) *Map {
 	m := &Map{
 		hash:     hash,
 		replicas: replicas,
-		hashmap:  make(map[int]string),
+		hashmap:  make(map[uint64][]string),
+		weights:  make(map[string]int),
 	}
 
 	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE
+		m.hash = fnv64a
 	}
 	return m
 }
 
+// fnv64a 计算 FNV-1a 64位哈希
+func fnv64a(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return h.Sum64()
+}
+
 // Add 添加缓存节点，允许传入 0 或 多个真实节点的名称。
 // 对每一个真实节点 key，对应创建 m.replicas 个虚拟节点，虚拟节点的名称是：strconv.Itoa(i) + key，即通过添加编号的方式区分不同虚拟节点。
-// 使用 m.hash() 计算虚拟节点的哈希值，使用 append(m.keys, hash) 添加到环上。
+// 使用 m.hash() 计算虚拟节点的哈希值，添加到环上。
 // 在 hashMap 中增加虚拟节点和真实节点的映射关系。
 // 最后一步，环上的哈希值排序。
 func (m *Map) Add(keys ...string) { // 真实节点的地址
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ { // 对于每一个真实节点的地址，创建对应的虚拟节点的名字， strconv.Itoa(i) + key
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
+		m.AddWeighted(key, 1)
+	}
+}
+
+// AddWeighted 按权重添加缓存节点，节点的虚拟节点数为 m.replicas * weight，
+// 这样机器配置越高的节点在哈希环上占的份额越大。weight 小于 1 时按 1 处理。
+// 如果节点已经存在，则用新的权重替换旧的虚拟节点。
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.Remove(key)
+	m.weights[key] = weight
+	added := false
+	for i := 0; i < m.replicas*weight; i++ { // 对于每一个真实节点的地址，创建对应的虚拟节点的名字， strconv.Itoa(i) + key
+		hash := m.hash([]byte(strconv.Itoa(i) + key))
+		owners, ok := m.hashmap[hash]
+		if !ok {
 			m.keys = append(m.keys, hash) // 将虚拟节点添加到哈希环上
-			m.hashmap[hash] = key         // 添加虚拟节点和真实节点的映射关系，可以通过虚拟节点找到真实节点
+			added = true
 		}
+		// 添加虚拟节点和真实节点的映射关系。两个虚拟节点哈希冲突时不覆盖，
+		// 而是都记录下来并按名称排序，保证无论添加顺序如何，冲突的哈希值总是归属同一个节点
+		owners = append(owners, key)
+		sort.Strings(owners)
+		m.hashmap[hash] = owners
 	}
-	sort.Ints(m.keys)
+	if added {
+		sort.Slice(m.keys, func(i, j int) bool { return m.keys[i] < m.keys[j] })
+	}
+}
+
+// Weight 返回真实节点的权重，节点不存在时返回 0
+func (m *Map) Weight(key string) int {
+	return m.weights[key]
 }
 
-// Get 获取缓存节点，通过key获取真实节点
+// Get 获取缓存节点，通过key获取真实节点，环上没有节点时返回空字符串
 // 第一步，计算 key 的哈希值。
 // 第二步，顺时针找到第一个匹配的虚拟节点的下标 idx，从 m.keys 中获取到对应的哈希值。
 // 如果 idx == len(m.keys)，说明应选择 m.keys[0]，因为 m.keys 是一个环状结构，所以用取余数的方式来处理这种情况。
 // 第三步，通过 hashMap 映射得到真实的节点。
 func (m *Map) Get(key string) string {
-	if len(key) == 0 {
+	if len(key) == 0 || len(m.keys) == 0 {
 		return ""
 	}
 
-	hash := int(m.hash([]byte(key)))
+	hash := m.hash([]byte(key))
 
 	idx := sort.Search(len(m.keys), func(i int) bool {
 		return m.keys[i] >= hash
 	}) // 在哈希环上顺时针找到第一个大于等于这个哈希值的虚拟节点的下标
 
-	return m.hashmap[m.keys[idx%len(m.keys)]] // 找到真实节点映射
+	return m.hashmap[m.keys[idx%len(m.keys)]][0] // 找到真实节点映射
 }
 
-// Remove 移除缓存节点
+// GetN 从key的位置开始在哈希环上顺时针查找，返回前 n 个不同的真实节点
+func (m *Map) GetN(key string, n int) []string {
+	if len(key) == 0 || len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.weights) {
+		n = len(m.weights)
+	}
+
+	hash := m.hash([]byte(key))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	res := make([]string, 0, n)
+	for i := 0; i < len(m.keys) && len(res) < n; i++ {
+		node := m.hashmap[m.keys[(idx+i)%len(m.keys)]][0]
+		if !contains(res, node) {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// Remove 移除缓存节点，节点不存在时什么也不做，可以重复调用
 func (m *Map) Remove(key string) {
-	for i := 0; i < m.replicas; i++ {
-		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-		idx := sort.SearchInts(m.keys, hash)
-		m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
+	weight, ok := m.weights[key]
+	if !ok {
+		return
+	}
+	for i := 0; i < m.replicas*weight; i++ {
+		hash := m.hash([]byte(strconv.Itoa(i) + key))
+		owners := m.hashmap[hash]
+		for j, owner := range owners {
+			if owner == key { // 只删除一个，同一节点的两个虚拟节点也可能冲突
+				owners = append(owners[:j], owners[j+1:]...)
+				break
+			}
+		}
+		if len(owners) > 0 {
+			m.hashmap[hash] = owners // 冲突的其他节点接管这个哈希值
+			continue
+		}
 		delete(m.hashmap, hash)
+		idx := sort.Search(len(m.keys), func(i int) bool {
+			return m.keys[i] >= hash
+		})
+		if idx < len(m.keys) && m.keys[idx] == hash {
+			m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
+		}
 	}
+	delete(m.weights, key)
 }
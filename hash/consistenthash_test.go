@@ -41,3 +41,107 @@ func TestHashing(t *testing.T) {
 	}
 
 }
+
+func TestWeightedHashing(t *testing.T) {
+	hash := NewConsistentHash(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// "6" 的权重为 2，虚拟节点为 6, 16, 26, 36, 46, 56
+	// "4" 的权重为 1，虚拟节点为 4, 14, 24
+	hash.AddWeighted("6", 2)
+	hash.AddWeighted("4", 1)
+
+	testCases := map[string]string{
+		"5":  "6",
+		"13": "4",
+		"30": "6",
+		"57": "4",
+	}
+	for k, v := range testCases {
+		if hash.Get(k) != v {
+			t.Errorf("Asking for %s, should have yielded %s", k, v)
+		}
+	}
+
+	// 把 "6" 的权重降为 1 后，虚拟节点只剩 6, 16, 26，30 顺时针落到 4
+	hash.AddWeighted("6", 1)
+	if hash.Get("30") != "4" || hash.Weight("6") != 1 {
+		t.Errorf("Asking for 30 after reweight, should have yielded 4")
+	}
+
+	hash.Remove("6")
+	if hash.Get("5") != "4" || hash.Weight("6") != 0 {
+		t.Errorf("Asking for 5 after remove, should have yielded 4")
+	}
+}
+
+func TestWeightedDistribution(t *testing.T) {
+	hash := NewConsistentHash(50, nil)
+	hash.AddWeighted("localhost:8001", 3)
+	hash.AddWeighted("localhost:8002", 1)
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[hash.Get("key"+strconv.Itoa(i))]++
+	}
+	if counts["localhost:8001"] < 2*counts["localhost:8002"] {
+		t.Errorf("weighted node should own most keys, got %v", counts)
+	}
+}
+
+func TestCollision(t *testing.T) {
+	// 所有虚拟节点的哈希值都落在 0~9 上，"1" 和 "11" 的虚拟节点全部冲突在 1
+	newMap := func() *Map {
+		return NewConsistentHash(3, func(key []byte) uint32 {
+			i, _ := strconv.Atoi(string(key))
+			return uint32(i % 10)
+		})
+	}
+	a, b := newMap(), newMap()
+	a.Add("1", "11")
+	b.Add("11", "1")
+	if a.Get("5") != "1" || b.Get("5") != "1" {
+		t.Fatalf("collision should be resolved deterministically, got %s and %s", a.Get("5"), b.Get("5"))
+	}
+
+	// 移除冲突的拥有者后，另一个节点接管；重复移除不会影响环
+	a.Remove("1")
+	a.Remove("1")
+	if a.Get("5") != "11" {
+		t.Fatalf("Asking for 5 after remove, should have yielded 11, got %q", a.Get("5"))
+	}
+	a.Remove("11")
+	if a.Get("5") != "" || len(a.keys) != 0 || len(a.hashmap) != 0 {
+		t.Fatalf("ring should be empty after removing all nodes")
+	}
+}
+
+func TestRemoveUnknown(t *testing.T) {
+	hash := NewConsistentHash(3, nil)
+	hash.Add("a", "b")
+	keys := len(hash.keys)
+	hash.Remove("c")
+	if len(hash.keys) != keys {
+		t.Fatalf("removing unknown node should not change the ring")
+	}
+	for i := 0; i < 100; i++ {
+		if owner := hash.Get(strconv.Itoa(i)); owner != "a" && owner != "b" {
+			t.Fatalf("Asking for %d yielded dangling node %q", i, owner)
+		}
+	}
+}
+
+func TestHashing64(t *testing.T) {
+	hash := NewConsistentHash64(50, nil)
+	hash.Add("a", "b", "c")
+	if len(hash.keys) != 150 {
+		t.Fatalf("expected 150 virtual nodes without collision, got %d", len(hash.keys))
+	}
+	owner := hash.Get("key")
+	hash.Remove(owner)
+	if hash.Get("key") == owner {
+		t.Fatalf("key should move after its owner is removed")
+	}
+}
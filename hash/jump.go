@@ -0,0 +1,102 @@
+package hash
+
+import "hash/crc32"
+
+// Jump 实现了 Lamping 和 Veach 的跳跃一致性哈希，把key映射到 [0, len(buckets)) 的桶编号上。
+// 查询不需要额外内存，但桶是有序的：只有在末尾增删节点时key的迁移量才是最小的，
+// 从中间移除节点会让其后所有桶的编号前移，产生较多迁移。
+type Jump struct {
+	hash    Hash     // 哈希算法
+	buckets []string // 桶到真实节点的映射，权重为 w 的节点占 w 个桶
+	weights map[string]int
+}
+
+// NewJump 创建一个 Jump，hash 为 nil 时使用 crc32.ChecksumIEEE
+func NewJump(hash Hash) *Jump {
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Jump{hash: hash, weights: make(map[string]int)}
+}
+
+// Add 添加权重为1的真实节点，新节点的桶追加在末尾
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		j.AddWeighted(node, 1)
+	}
+}
+
+// AddWeighted 按权重添加真实节点，节点已存在时先移除再追加到末尾
+func (j *Jump) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	j.Remove(node)
+	for i := 0; i < weight; i++ {
+		j.buckets = append(j.buckets, node)
+	}
+	j.weights[node] = weight
+}
+
+// Remove 移除真实节点占用的所有桶
+func (j *Jump) Remove(node string) {
+	if _, ok := j.weights[node]; !ok {
+		return
+	}
+	buckets := j.buckets[:0]
+	for _, b := range j.buckets {
+		if b != node {
+			buckets = append(buckets, b)
+		}
+	}
+	j.buckets = buckets
+	delete(j.weights, node)
+}
+
+// Get 获取key对应的真实节点
+func (j *Jump) Get(key string) string {
+	if len(key) == 0 || len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(mix64(uint64(j.hash([]byte(key)))), len(j.buckets))]
+}
+
+// GetN 返回key的前 n 个不同的真实节点：第一个与 Get 相同，之后用不同的种子重新计算跳跃哈希，
+// 仍然凑不够时按桶的顺序补齐
+func (j *Jump) GetN(key string, n int) []string {
+	if len(key) == 0 || len(j.buckets) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(j.weights) {
+		n = len(j.weights)
+	}
+	seed := mix64(uint64(j.hash([]byte(key))))
+	res := make([]string, 0, n)
+	for i := 0; i < 8*len(j.buckets) && len(res) < n; i++ {
+		node := j.buckets[jumpHash(seed, len(j.buckets))]
+		if !contains(res, node) {
+			res = append(res, node)
+		}
+		seed = mix64(seed + 1)
+	}
+	for _, node := range j.buckets {
+		if len(res) >= n {
+			break
+		}
+		if !contains(res, node) {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// jumpHash 是论文 "A Fast, Minimal Memory, Consistent Hash Algorithm" 中的算法
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
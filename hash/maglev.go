@@ -0,0 +1,149 @@
+package hash
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+const defaultMaglevSize = 65537 // 查找表大小，必须是质数，且远大于节点数量
+
+// Maglev 实现了 Google Maglev 论文中的查表哈希：每个节点按自己的排列顺序轮流抢占查找表的槽位，
+// 查询时只需一次取模和一次数组访问。节点变化时需要重建查找表，少量不属于变化节点的key也会迁移。
+type Maglev struct {
+	hash    Hash           // 哈希算法
+	size    uint64         // 配置的查找表大小，已向上取为质数
+	weights map[string]int // 真实节点和权重的映射关系
+	table   []string       // 查找表，槽位到真实节点的映射，长度是实际使用的查找表大小
+}
+
+// NewMaglev 创建一个 Maglev，size 为查找表大小，小于等于0时使用默认值 65537，不是质数时向上取最近的质数；
+// 节点数超过 size 时，查找表按节点数扩大到下一个质数。hash 为 nil 时使用 crc32.ChecksumIEEE
+func NewMaglev(size int, hash Hash) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Maglev{hash: hash, size: nextPrime(uint64(size)), weights: make(map[string]int)}
+}
+
+// nextPrime 返回不小于 n 且大于 1 的最小质数。查找表大小是质数时，每个节点的 skip 都与它互质，排列才能覆盖所有槽位
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for d := uint64(2); d*d <= n; d++ {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+// Add 添加权重为1的真实节点
+func (m *Maglev) Add(nodes ...string) {
+	for _, node := range nodes {
+		m.weights[node] = 1
+	}
+	m.populate()
+}
+
+// AddWeighted 按权重添加真实节点，权重为 w 的节点每一轮抢占 w 个槽位
+func (m *Maglev) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	m.weights[node] = weight
+	m.populate()
+}
+
+// Remove 移除真实节点
+func (m *Maglev) Remove(node string) {
+	if _, ok := m.weights[node]; !ok {
+		return
+	}
+	delete(m.weights, node)
+	m.populate()
+}
+
+// Get 获取key对应的真实节点
+func (m *Maglev) Get(key string) string {
+	if len(key) == 0 || len(m.table) == 0 {
+		return ""
+	}
+	return m.table[mix64(uint64(m.hash([]byte(key))))%uint64(len(m.table))]
+}
+
+// GetN 从key所在的槽位开始向后查找，返回前 n 个不同的真实节点
+func (m *Maglev) GetN(key string, n int) []string {
+	if len(key) == 0 || len(m.table) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.weights) {
+		n = len(m.weights)
+	}
+	size := uint64(len(m.table))
+	slot := mix64(uint64(m.hash([]byte(key)))) % size
+	res := make([]string, 0, n)
+	for i := uint64(0); i < size && len(res) < n; i++ {
+		node := m.table[(slot+i)%size]
+		if !contains(res, node) {
+			res = append(res, node)
+		}
+	}
+	return res
+}
+
+// populate 重建查找表。每个节点根据 offset 和 skip 生成一个 [0, size) 的排列，
+// 所有节点按名称顺序轮流沿着自己的排列抢占下一个空槽位，直到查找表填满。
+// 槽位是否已被占用记录在单独的位图中，节点名称可以是空串
+func (m *Maglev) populate() {
+	if len(m.weights) == 0 {
+		m.table = nil
+		return
+	}
+	nodes := make([]string, 0, len(m.weights))
+	for node := range m.weights {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	size := m.size
+	if size < uint64(len(nodes)) {
+		size = nextPrime(uint64(len(nodes)))
+	}
+
+	offsets := make([]uint64, len(nodes))
+	skips := make([]uint64, len(nodes))
+	next := make([]uint64, len(nodes))
+	for i, node := range nodes {
+		offsets[i] = mix64(uint64(m.hash([]byte("offset"+node)))) % size
+		skips[i] = mix64(uint64(m.hash([]byte("skip"+node))))%(size-1) + 1
+	}
+
+	table := make([]string, size)
+	used := make([]uint64, (size+63)/64) // 已被占用的槽位
+	filled := uint64(0)
+	for filled < size {
+		for i, node := range nodes {
+			for w := 0; w < m.weights[node] && filled < size; w++ {
+				slot := (offsets[i] + next[i]*skips[i]) % size
+				for used[slot/64]&(1<<(slot%64)) != 0 {
+					next[i]++
+					slot = (offsets[i] + next[i]*skips[i]) % size
+				}
+				table[slot] = node
+				used[slot/64] |= 1 << (slot % 64)
+				next[i]++
+				filled++
+			}
+		}
+	}
+	m.table = table
+}
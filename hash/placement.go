@@ -0,0 +1,42 @@
+package hash
+
+// Placement 抽象了“根据key选择真实节点”的放置算法。
+// 不同算法在查询速度、负载均衡程度、节点变化时key的迁移量之间各有取舍：
+//   - Map：一致性哈希环，O(log n) 查询，迁移量小，均衡程度取决于虚拟节点数
+//   - Rendezvous：最高随机权重（HRW）哈希，O(n) 查询，均衡且迁移量最小
+//   - Jump：跳跃一致性哈希，O(log n) 查询且几乎不占内存，但只有在末尾增删节点时迁移量才最小
+//   - Maglev：查表哈希，O(1) 查询，均衡性好，节点变化时有少量额外迁移
+type Placement interface {
+	Add(nodes ...string)                 // 添加权重为1的真实节点
+	AddWeighted(node string, weight int) // 按权重添加真实节点，节点已存在时更新权重
+	Remove(node string)                  // 移除真实节点
+	Get(key string) string               // 获取key对应的真实节点，没有节点时返回空字符串
+	GetN(key string, n int) []string     // 按优先顺序返回key的前 n 个不同的真实节点，第一个与 Get 相同，拥有者不可用时可依次尝试后面的节点
+}
+
+var (
+	_ Placement = (*Map)(nil)
+	_ Placement = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+)
+
+// mix64 是 splitmix64 的混淆函数，用于把分布较差的哈希值（例如短字符串的crc32）打散到整个64位空间
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// contains 判断 nodes 中是否包含 node，GetN 的 n 通常很小，线性查找即可
+func contains(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package hash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+var placements = map[string]func() Placement{
+	"ring":       func() Placement { return NewConsistentHash(50, nil) },
+	"rendezvous": func() Placement { return NewRendezvous(nil) },
+	"jump":       func() Placement { return NewJump(nil) },
+	"maglev":     func() Placement { return NewMaglev(0, nil) },
+}
+
+const numKeys = 20000
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = fmt.Sprintf("10.0.0.%d:8001", i+1)
+	}
+	return nodes
+}
+
+func owners(p Placement) []string {
+	res := make([]string, numKeys)
+	for i := range res {
+		res[i] = p.Get("key" + strconv.Itoa(i))
+	}
+	return res
+}
+
+func TestPlacementEmpty(t *testing.T) {
+	for name, newPlacement := range placements {
+		if got := newPlacement().Get("key"); got != "" {
+			t.Errorf("%s: empty placement should return \"\", got %q", name, got)
+		}
+	}
+}
+
+// 每个节点分到的key应该接近平均值
+func TestPlacementBalance(t *testing.T) {
+	nodes := nodeNames(5)
+	for name, newPlacement := range placements {
+		p := newPlacement()
+		p.Add(nodes...)
+		counts := map[string]int{}
+		for _, owner := range owners(p) {
+			counts[owner]++
+		}
+		for _, node := range nodes {
+			if share := float64(counts[node]) / numKeys; share < 0.1 || share > 0.3 {
+				t.Errorf("%s: node %s owns %.2f of keys", name, node, share)
+			}
+		}
+	}
+}
+
+// 添加节点时，只有被新节点接管的key才允许迁移
+func TestPlacementMovementOnAdd(t *testing.T) {
+	nodes := nodeNames(6)
+	for name, newPlacement := range placements {
+		p := newPlacement()
+		p.Add(nodes[:5]...)
+		before := owners(p)
+		p.Add(nodes[5])
+		after := owners(p)
+
+		moved, movedElsewhere := 0, 0
+		for i := range before {
+			if before[i] != after[i] {
+				moved++
+				if after[i] != nodes[5] {
+					movedElsewhere++
+				}
+			}
+		}
+		t.Logf("%s: %.3f of keys moved on add", name, float64(moved)/numKeys)
+		if share := float64(moved) / numKeys; share > 0.3 {
+			t.Errorf("%s: too many keys moved on add: %.3f", name, share)
+		}
+		// maglev 重建查找表时允许少量额外迁移
+		if limit := allowedExtraMoves(name); movedElsewhere > limit {
+			t.Errorf("%s: %d keys moved between old nodes on add", name, movedElsewhere)
+		}
+	}
+}
+
+// 移除节点时，只有原本属于该节点的key才允许迁移（jump 只在移除末尾节点时满足）
+func TestPlacementMovementOnRemove(t *testing.T) {
+	nodes := nodeNames(6)
+	for name, newPlacement := range placements {
+		p := newPlacement()
+		p.Add(nodes...)
+		before := owners(p)
+		p.Remove(nodes[5])
+		after := owners(p)
+
+		movedElsewhere := 0
+		for i := range before {
+			if after[i] == nodes[5] {
+				t.Fatalf("%s: key still mapped to removed node", name)
+			}
+			if before[i] != nodes[5] && before[i] != after[i] {
+				movedElsewhere++
+			}
+		}
+		if limit := allowedExtraMoves(name); movedElsewhere > limit {
+			t.Errorf("%s: %d keys of surviving nodes moved on remove", name, movedElsewhere)
+		}
+	}
+}
+
+func allowedExtraMoves(name string) int {
+	if name == "maglev" {
+		return numKeys / 20
+	}
+	return 0
+}
+
+func TestPlacementWeighted(t *testing.T) {
+	for name, newPlacement := range placements {
+		p := newPlacement()
+		p.AddWeighted("10.0.0.1:8001", 3)
+		p.AddWeighted("10.0.0.2:8001", 1)
+		counts := map[string]int{}
+		for _, owner := range owners(p) {
+			counts[owner]++
+		}
+		if counts["10.0.0.1:8001"] < 2*counts["10.0.0.2:8001"] {
+			t.Errorf("%s: weighted node should own most keys, got %v", name, counts)
+		}
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	for _, n := range []int{8, 64} {
+		for name, newPlacement := range placements {
+			p := newPlacement()
+			p.Add(nodeNames(n)...)
+			b.Run(fmt.Sprintf("%s/%d", name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.Get("key" + strconv.Itoa(i&1023))
+				}
+			})
+		}
+	}
+}
+
+func TestDelta(t *testing.T) {
+	nodes := nodeNames(4)
+	old := NewConsistentHash(50, nil)
+	old.Add(nodes[:3]...)
+	new := NewConsistentHash(50, nil)
+	new.Add(nodes...)
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	moves := Delta(old, new, keys)
+	if len(moves) == 0 {
+		t.Fatalf("adding a node should move some keys")
+	}
+	for _, m := range moves {
+		if m.To != nodes[3] || m.From != old.Get(m.Key) {
+			t.Fatalf("unexpected move %+v", m)
+		}
+	}
+	if moves := Delta(new, new, keys); len(moves) != 0 {
+		t.Fatalf("same placement should not move keys, got %d", len(moves))
+	}
+}
+
+func TestPlacementGetN(t *testing.T) {
+	nodes := nodeNames(5)
+	for name, newPlacement := range placements {
+		p := newPlacement()
+		p.Add(nodes...)
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			res := p.GetN(key, 3)
+			if len(res) != 3 || res[0] != p.Get(key) || res[0] == res[1] || res[1] == res[2] || res[0] == res[2] {
+				t.Fatalf("%s: GetN(%s, 3) = %v, Get = %s", name, key, res, p.Get(key))
+			}
+		}
+		if res := p.GetN("key", 10); len(res) != len(nodes) {
+			t.Errorf("%s: GetN should be capped by node count, got %v", name, res)
+		}
+	}
+
+	// 对哈希环和 Rendezvous，拥有者被移除后key恰好落到 GetN 给出的第二个节点
+	for _, name := range []string{"ring", "rendezvous"} {
+		p := placements[name]()
+		p.Add(nodes...)
+		for i := 0; i < 1000; i++ {
+			key := "key" + strconv.Itoa(i)
+			res := p.GetN(key, 2)
+			p.Remove(res[0])
+			if got := p.Get(key); got != res[1] {
+				t.Fatalf("%s: successor of %s should be %s, got %s", name, key, res[1], got)
+			}
+			p.Add(res[0])
+		}
+	}
+}
+
+// 查找表大小不是质数、小于节点数或节点名称为空时，查找表也要能填满
+func TestMaglevSize(t *testing.T) {
+	for _, size := range []int{1, 2, 4, 100} {
+		m := NewMaglev(size, nil)
+		m.Add(nodeNames(5)...)
+		if len(m.table) < 5 {
+			t.Fatalf("size %d: table should have at least one slot per node, got %d", size, len(m.table))
+		}
+		counts := map[string]int{}
+		for _, node := range m.table {
+			counts[node]++
+		}
+		if len(counts) != 5 {
+			t.Fatalf("size %d: every node should own a slot, got %v", size, counts)
+		}
+	}
+
+	m := NewMaglev(7, nil)
+	m.Add("", "a")
+	counts := map[string]int{}
+	for _, node := range m.table {
+		counts[node]++
+	}
+	if counts[""] == 0 || counts["a"] == 0 || counts[""]+counts["a"] != 7 {
+		t.Fatalf("empty node name should own slots like any other node, got %v", counts)
+	}
+}
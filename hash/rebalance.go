@@ -0,0 +1,27 @@
+package hash
+
+// Move 描述一个key在节点变化前后的归属
+type Move struct {
+	Key  string
+	From string // 变化前的拥有者
+	To   string // 变化后的拥有者
+}
+
+// Delta 计算 keys 在节点变化前（old）和变化后（new）的归属差异，只返回归属发生变化的key。
+// old 或 new 为 nil 时视为没有任何节点。
+func Delta(old, new Placement, keys []string) []Move {
+	var moves []Move
+	for _, key := range keys {
+		var from, to string
+		if old != nil {
+			from = old.Get(key)
+		}
+		if new != nil {
+			to = new.Get(key)
+		}
+		if from != to {
+			moves = append(moves, Move{Key: key, From: from, To: to})
+		}
+	}
+	return moves
+}
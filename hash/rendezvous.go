@@ -0,0 +1,104 @@
+package hash
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+// Rendezvous 实现了最高随机权重（HRW）哈希：对每个key，给所有节点打分，分数最高的节点即为key所在的节点。
+// 节点增删时只有原本属于该节点的key会迁移，代价是每次查询需要遍历所有节点。
+type Rendezvous struct {
+	hash  Hash             // 哈希算法
+	nodes []rendezvousNode // 真实节点，按名称排序，保证分数相同时结果确定
+}
+
+type rendezvousNode struct {
+	name   string
+	hash   uint64 // 节点名称的哈希值，提前计算好避免每次查询重复计算
+	weight int
+}
+
+// NewRendezvous 创建一个 Rendezvous，hash 为 nil 时使用 crc32.ChecksumIEEE
+func NewRendezvous(hash Hash) *Rendezvous {
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{hash: hash}
+}
+
+// Add 添加权重为1的真实节点
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		r.AddWeighted(node, 1)
+	}
+}
+
+// AddWeighted 按权重添加真实节点，节点已存在时更新权重
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	r.Remove(node)
+	r.nodes = append(r.nodes, rendezvousNode{
+		name:   node,
+		hash:   mix64(uint64(r.hash([]byte(node)))),
+		weight: weight,
+	})
+	sort.Slice(r.nodes, func(i, j int) bool { return r.nodes[i].name < r.nodes[j].name })
+}
+
+// Remove 移除真实节点
+func (r *Rendezvous) Remove(node string) {
+	for i, n := range r.nodes {
+		if n.name == node {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Get 计算每个节点对 key 的加权分数，返回分数最高的节点
+func (r *Rendezvous) Get(key string) string {
+	if len(key) == 0 || len(r.nodes) == 0 {
+		return ""
+	}
+	keyHash := mix64(uint64(r.hash([]byte(key))))
+	best, bestScore := "", math.Inf(-1)
+	for _, n := range r.nodes {
+		if score := n.score(keyHash); score > bestScore {
+			best, bestScore = n.name, score
+		}
+	}
+	return best
+}
+
+// GetN 按分数从高到低返回前 n 个节点
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(key) == 0 || len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	keyHash := mix64(uint64(r.hash([]byte(key))))
+	scores := make([]float64, len(r.nodes))
+	order := make([]int, len(r.nodes))
+	for i, node := range r.nodes {
+		scores[i] = node.score(keyHash)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+	res := make([]string, n)
+	for i := range res {
+		res[i] = r.nodes[order[i]].name
+	}
+	return res
+}
+
+// score 计算节点对 key 的加权分数 weight / -ln(u)，u 是节点和key共同决定的 (0,1) 之间的伪随机数
+func (n rendezvousNode) score(keyHash uint64) float64 {
+	h := mix64(keyHash ^ n.hash)
+	u := (float64(h>>11) + 0.5) / (1 << 53) // 取高53位映射到 (0,1)
+	return float64(n.weight) / -math.Log(u)
+}
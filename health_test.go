@@ -0,0 +1,117 @@
+package tinycache
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	pb "tinycache/tinycachepb"
+)
+
+func checkHealth(t *testing.T, client healthpb.HealthClient, service string, want healthpb.HealthCheckResponse_ServingStatus) {
+	t.Helper()
+	res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != want {
+		t.Fatalf("health of %q: got %v, want %v", service, res.Status, want)
+	}
+}
+
+func TestGRPCHealth(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, _ := NewServer(lis.Addr().String(), WithWarmup(), WithBearerToken("s3cret"))
+	go svr.Serve(lis)
+	defer svr.Shutdown(context.Background())
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn) // 健康检查不需要令牌
+
+	service := pb.GroupCache_ServiceDesc.ServiceName
+	checkHealth(t, client, "", healthpb.HealthCheckResponse_NOT_SERVING)
+	checkHealth(t, client, service, healthpb.HealthCheckResponse_NOT_SERVING)
+	svr.SetReady(true)
+	checkHealth(t, client, "", healthpb.HealthCheckResponse_SERVING)
+	checkHealth(t, client, service, healthpb.HealthCheckResponse_SERVING)
+
+	// 通过 Start 启动的节点，只有注册到etcd之后才健康
+	svr.mu.Lock()
+	svr.status = true
+	svr.mu.Unlock()
+	svr.updateHealth()
+	checkHealth(t, client, service, healthpb.HealthCheckResponse_NOT_SERVING)
+	svr.setRegistered(true)
+	checkHealth(t, client, service, healthpb.HealthCheckResponse_SERVING)
+	svr.setRegistered(false)
+	checkHealth(t, client, service, healthpb.HealthCheckResponse_NOT_SERVING)
+	svr.mu.Lock()
+	svr.status = false
+	svr.mu.Unlock()
+}
+
+func TestGRPCReflection(t *testing.T) {
+	addr, _ := startTransports(t)
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := map[string]bool{}
+	for _, s := range res.GetListServicesResponse().GetService() {
+		services[s.Name] = true
+	}
+	for _, want := range []string{pb.GroupCache_ServiceDesc.ServiceName, "grpc.health.v1.Health"} {
+		if !services[want] {
+			t.Fatalf("reflection should list %s, got %v", want, services)
+		}
+	}
+}
+
+func TestHTTPHealth(t *testing.T) {
+	pool := NewHTTPPool("http://127.0.0.1", WithWarmup())
+	ts := httptest.NewServer(pool)
+	defer ts.Close()
+
+	expect := func(path string, want int) {
+		t.Helper()
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("GET %s: got %d, want %d", path, res.StatusCode, want)
+		}
+	}
+	expect("/healthz", http.StatusOK)
+	expect("/readyz", http.StatusServiceUnavailable)
+	pool.SetReady(true)
+	expect("/readyz", http.StatusOK)
+}
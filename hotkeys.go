@@ -0,0 +1,103 @@
+package tinycache
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"tinycache/topk"
+)
+
+const (
+	defaultHotKeyCapacity = 128 // 每种统计默认记录的 key 数
+	defaultHotKeyLimit    = 10  // HTTP 查询热点 key 时默认返回的 key 数
+)
+
+// hotKeyTrackers 用 space-saving 算法统计请求最多、未命中最多和返回字节数最多的 key，为 nil 时不统计
+type hotKeyTrackers struct {
+	gets   *topk.Tracker
+	misses *topk.Tracker
+	bytes  *topk.Tracker
+}
+
+func newHotKeyTrackers(capacity int) *hotKeyTrackers {
+	if capacity <= 0 {
+		return nil
+	}
+	return &hotKeyTrackers{gets: topk.New(capacity), misses: topk.New(capacity), bytes: topk.New(capacity)}
+}
+
+func (t *hotKeyTrackers) get(key string) {
+	if t != nil {
+		t.gets.Add(key, 1)
+	}
+}
+
+func (t *hotKeyTrackers) miss(key string) {
+	if t != nil {
+		t.misses.Add(key, 1)
+	}
+}
+
+func (t *hotKeyTrackers) returned(key string, value ByteView) {
+	if t != nil {
+		t.bytes.Add(key, int64(value.Len()))
+	}
+}
+
+// HotKeys 是 Group 在本节点上的热点 key，计数是估计值，详见 topk.Counter
+type HotKeys struct {
+	Gets   []topk.Counter `json:"gets"`   // 请求次数最多的 key
+	Misses []topk.Counter `json:"misses"` // 未命中 hotCache 和 mainCache 次数最多的 key
+	Bytes  []topk.Counter `json:"bytes"`  // 返回字节数最多的 key
+}
+
+// WithHotKeyCapacity 设置热点 key 统计中每种统计记录的 key 数，默认为 128，n<=0 时关闭统计。
+// 计数大于总数 1/n 的 key 一定会被记录
+func WithHotKeyCapacity(n int) GroupOption {
+	return func(g *Group) {
+		g.hotKeys = newHotKeyTrackers(n)
+	}
+}
+
+// HotKeys 按计数从大到小返回本节点上请求最多、未命中最多和返回字节数最多的至多 n 个 key，n<=0 时返回全部
+func (g *Group) HotKeys(n int) HotKeys {
+	if g.hotKeys == nil {
+		return HotKeys{}
+	}
+	return HotKeys{Gets: g.hotKeys.gets.Top(n), Misses: g.hotKeys.misses.Top(n), Bytes: g.hotKeys.bytes.Top(n)}
+}
+
+// ResetHotKeys 清空热点 key 的统计，用于观察某一段时间内的热点
+func (g *Group) ResetHotKeys() {
+	if g.hotKeys != nil {
+		g.hotKeys.gets.Reset()
+		g.hotKeys.misses.Reset()
+		g.hotKeys.bytes.Reset()
+	}
+}
+
+// serveHotKeys 处理 /admin/hotkeys，以 JSON 格式返回本节点的热点 key。
+// 参数 group 指定 Group（为空时返回所有 Group），n 指定每种统计返回的 key 数（默认 10）
+func serveHotKeys(w http.ResponseWriter, q url.Values) {
+	n := defaultHotKeyLimit
+	if s := q.Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "bad n: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	list := sortedGroups()
+	if name := q.Get("group"); name != "" {
+		g, ok := adminGroupParam(w, name)
+		if !ok {
+			return
+		}
+		list = []*Group{g}
+	}
+	res := make(map[string]HotKeys, len(list))
+	for _, g := range list {
+		res[g.name] = g.HotKeys(n)
+	}
+	writeJSON(w, res)
+}
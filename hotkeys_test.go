@@ -0,0 +1,63 @@
+package tinycache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	pb "tinycache/tinycachepb"
+	"tinycache/topk"
+)
+
+func TestGroupHotKeys(t *testing.T) {
+	g := NewGroup("hotkeys", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	for i := 0; i < 3; i++ {
+		g.Get("Tom")
+	}
+	g.Get("Jack")
+	g.Get("Sam")
+	g.Get("Sam")
+
+	hot := g.HotKeys(2)
+	want := HotKeys{
+		Gets:   []topk.Counter{{Key: "Tom", Count: 3}, {Key: "Sam", Count: 2}},
+		Misses: []topk.Counter{{Key: "Jack", Count: 1}, {Key: "Sam", Count: 1}},
+		Bytes:  []topk.Counter{{Key: "Tom", Count: 9}, {Key: "Sam", Count: 6}},
+	}
+	if !reflect.DeepEqual(hot, want) {
+		t.Fatalf("got %+v, want %+v", hot, want)
+	}
+
+	pool := NewHTTPPool("http://127.0.0.1", WithAdminToken("secret"))
+	if code, _ := adminDo(t, pool, http.MethodGet, "/admin/hotkeys", ""); code != http.StatusUnauthorized {
+		t.Fatalf("hot keys without admin token: got %d", code)
+	}
+	code, res := adminDo(t, pool, http.MethodGet, "/admin/hotkeys?group=hotkeys&n=1", "secret")
+	if code != http.StatusOK {
+		t.Fatalf("GET /admin/hotkeys: %d %s", code, res)
+	}
+	var body map[string]HotKeys
+	if err := json.Unmarshal([]byte(res), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got := body["hotkeys"].Gets; len(body) != 1 || !reflect.DeepEqual(got, want.Gets[:1]) {
+		t.Fatalf("unexpected response: %+v", body)
+	}
+	if code, _ := adminDo(t, pool, http.MethodGet, "/admin/hotkeys?group=hotkeys-unknown", "secret"); code != http.StatusNotFound {
+		t.Fatalf("unknown group: got %d", code)
+	}
+
+	svr, _ := NewServer("127.0.0.1:0")
+	rpc, err := svr.HotKeys(context.Background(), &pb.HotKeysRequest{Group: "hotkeys", Limit: 1})
+	if err != nil || len(rpc.Misses) != 1 || rpc.Misses[0].Key != "Jack" || rpc.Bytes[0].Count != 9 {
+		t.Fatalf("HotKeys RPC: %v %v", rpc, err)
+	}
+
+	g.ResetHotKeys()
+	if hot := g.HotKeys(0); len(hot.Gets) != 0 {
+		t.Fatalf("expected no hot keys after reset, got %+v", hot)
+	}
+}
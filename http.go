@@ -1,14 +1,20 @@
 package tinycache
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"tinycache/hash"
 	pb "tinycache/tinycachepb"
 )
@@ -16,30 +22,184 @@ import (
 const defaultPath = "/_tinycache/"
 const defaultReplicas = 50
 
+// streamContentType 是分段传输缓存值时使用的 Content-Type，请求的 Accept 头为它时，
+// 响应体直接是缓存值的原始字节，以 chunked 编码分段发送，元数据放在响应头中
+const streamContentType = "application/x-tinycache-stream"
+
+// hedgedParam 为 1 时表示对冲请求，对应 pb.Request 的 hedged 字段
+const hedgedParam = "hedged"
+
+// 分段传输时携带元数据的响应头
+const (
+	headerVersion  = "X-Tinycache-Version"
+	headerLoadTime = "X-Tinycache-Load-Time"
+	headerSize     = "X-Tinycache-Size"
+)
+
 type HTTPPOOL struct {
 	self       string                 // 记录自己的地址, e.g. "http://localhost:8001"
 	basePath   string                 // 节点间通讯地址的前缀
 	mu         sync.Mutex             // guards peers and httpGetters
-	peers      *hash.Map              // 一致性哈希算法的实例
+	peers      hash.Placement         // 节点放置算法的实例，默认为一致性哈希
 	httpGetter map[string]*httpGetter // 每一个远程节点地址对应一个 httpGetter
+	opts       options                // 可选配置
+	ready      int32                  // 是否就绪，原子访问
 }
 
-func NewHTTPPool(s string) *HTTPPOOL {
-	return &HTTPPOOL{
+func NewHTTPPool(s string, opts ...Option) *HTTPPOOL {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	p := &HTTPPOOL{
 		self:     s,
 		basePath: defaultPath,
+		opts:     o,
 	}
+	p.SetReady(!o.warmup)
+	return p
 }
 
+// 健康检查的路径，供负载均衡和 Kubernetes 探针使用
+const (
+	healthzPath = "/healthz" // 存活检查，进程能处理请求就返回 200
+	readyzPath  = "/readyz"  // 就绪检查，未就绪（例如还在预热）时返回 503
+)
+
+// SetReady 设置节点是否就绪，例如预热完成后调用 SetReady(true)
+func (p *HTTPPOOL) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&p.ready, v)
+}
+
+// serveHealth 处理 /healthz 和 /readyz
+func (p *HTTPPOOL) serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Path == readyzPath && atomic.LoadInt32(&p.ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("not ready\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式导出指标的 http.Handler，HTTPPOOL 自身也在 /metrics 上提供同样的内容
+func (p *HTTPPOOL) MetricsHandler() http.Handler {
+	return metricsHandler(func() nodeMetrics {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		n := nodeMetrics{node: p.self, ring: len(p.httpGetter), peers: map[string]*histogram{}, rpc: p.opts.rpcMetrics}
+		for peer, getter := range p.httpGetter {
+			if peer != p.self {
+				n.peers[peer] = &getter.pg.hist
+			}
+		}
+		return n
+	})
+}
+
+// AdminHandler 返回管理接口的 http.Handler，需要通过 WithAdminToken 设置令牌，HTTPPOOL 自身也在 /admin/ 上提供同样的接口
+func (p *HTTPPOOL) AdminHandler() http.Handler {
+	return adminHandler(adminNode{
+		self:  p.self,
+		token: p.opts.adminToken,
+		peers: func() []string {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			peers := make([]string, 0, len(p.httpGetter))
+			for peer := range p.httpGetter {
+				peers = append(peers, peer)
+			}
+			return peers
+		},
+		owner: func(key string) string {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.peers == nil {
+				return ""
+			}
+			return p.peers.Get(key)
+		},
+	})
+}
+
+// TLSConfig 返回服务端的 TLS 配置，用于创建 http.Server，例如
+//
+//	srv := &http.Server{Addr: addr, Handler: pool, TLSConfig: pool.TLSConfig()}
+//	srv.ListenAndServeTLS("", "")
+//
+// 没有配置 WithTLS 时返回 nil
+func (p *HTTPPOOL) TLSConfig() *tls.Config {
+	if p.opts.tls == nil {
+		return nil
+	}
+	return p.opts.tls.serverConfig(p.isPeerHost)
+}
+
+// verifyPeerRequest 校验节点间请求的来源，与 gRPC 的节点间请求使用相同的凭据：
+// 启用了 mTLS 时，已在握手时校验过客户端证书的请求直接通过；配置了 WithBearerToken 或 WithHMACAuth 时校验请求头中的认证信息。
+// 两者都没有配置时不校验
+func (p *HTTPPOOL) verifyPeerRequest(r *http.Request) error {
+	mtls := p.opts.tls != nil && p.opts.tls.cfg.ClientAuth
+	if mtls && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return nil
+	}
+	if p.opts.auth == nil {
+		if mtls {
+			return fmt.Errorf("client certificate is required")
+		}
+		return nil
+	}
+	md := metadata.MD{}
+	for k, v := range r.Header {
+		md[strings.ToLower(k)] = v
+	}
+	return p.opts.auth.verify(md, r.Method+" "+r.URL.RequestURI(), nil)
+}
+
+// isPeerHost 判断 host 是否是集群中某个节点的主机地址，用于校验客户端证书
+func (p *HTTPPOOL) isPeerHost(host string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if peerHost(p.self) == host {
+		return true
+	}
+	for peer := range p.httpGetter {
+		if peerHost(peer) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Log 以 Info 级别记录一条日志，日志由 WithLogger 配置
 func (p *HTTPPOOL) Log(format string, v ...interface{}) {
-	log.Printf(("[SERVER %s]%s"), p.self, fmt.Sprintf(format, v...))
+	p.opts.log.get().Info(fmt.Sprintf(format, v...), "node", p.self)
 }
 
 func (p *HTTPPOOL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == healthzPath || r.URL.Path == readyzPath {
+		p.serveHealth(w, r)
+		return
+	}
+	if r.URL.Path == metricsPath {
+		p.MetricsHandler().ServeHTTP(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, adminPath) {
+		p.AdminHandler().ServeHTTP(w, r)
+		return
+	}
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		panic("HTTPPool serving unexpected path: " + r.URL.Path)
 	}
-	p.Log("%s %s", r.Method, r.URL.Path)
+	if err := p.verifyPeerRequest(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	// /<basepath>/<groupname>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
 	if len(parts) != 2 {
@@ -49,6 +209,7 @@ func (p *HTTPPOOL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	groupName := parts[0]
 	key := parts[1]
+	p.opts.log.request("recv http request", "node", p.self, "method", r.Method, "group", groupName, keyHash(key))
 
 	group := GetGroup(groupName)
 	if group == nil {
@@ -56,14 +217,45 @@ func (p *HTTPPOOL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key)
+	// DELETE 请求为其他节点广播的失效消息，序号通过 seq 参数传递
+	if r.Method == http.MethodDelete {
+		seq, err := strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64)
+		if err != nil {
+			http.Error(w, "bad seq: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := group.applyRemoteInvalidation(key, seq); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ctx, span := startSpan(extractTraceHeader(r), "tinycache.HTTPPOOL.Get")
+	span.SetAttribute("node", p.self)
+	if r.URL.Query().Get(hedgedParam) == "1" {
+		ctx = withHedged(ctx)
+	}
+	view, err := group.GetContext(ctx, key)
+	endSpan(span, err)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if r.Header.Get("Accept") == streamContentType {
+		p.serveStream(w, view)
+		return
+	}
+
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	body, err := proto.Marshal(&pb.Response{
+		Value:    view.ByteSlice(),
+		Version:  view.Version(),
+		LoadTime: view.LoadTime().UnixNano(),
+		Protocol: ProtocolVersion,
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -73,33 +265,113 @@ func (p *HTTPPOOL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// serveStream 把缓存值按 streamChunkSize 分段写入响应体，每写一段就 Flush 一次
+func (p *HTTPPOOL) serveStream(w http.ResponseWriter, view ByteView) {
+	w.Header().Set("Content-Type", streamContentType)
+	w.Header().Set(headerVersion, strconv.FormatUint(view.Version(), 10))
+	w.Header().Set(headerLoadTime, strconv.FormatInt(view.LoadTime().UnixNano(), 10))
+	w.Header().Set(headerSize, strconv.Itoa(view.Len()))
+	flusher, _ := w.(http.Flusher)
+	r := view.Reader()
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 //-------------------------------------------------------------------------
 // 客户端类的实现
 
 type httpGetter struct {
-	baseURL string // 即将访问的远程节点的地址，http://example.com/_geecache/group名
+	baseURL string       // 即将访问的远程节点的地址，http://example.com/_geecache/group名
+	client  *http.Client // 带超时的 http 客户端
+	pg      *peerGuard   // 熔断器、重试策略和延迟统计
+	auth    peerAuth     // 节点间的认证方式，为 nil 表示不认证
+}
+
+// newHTTPGetter 按节点的可选配置创建访问远程节点的 httpGetter
+func newHTTPGetter(baseURL string, o options) *httpGetter {
+	client := &http.Client{Timeout: o.timeout}
+	if o.tls != nil {
+		// 服务端证书必须包含节点地址中的主机
+		client.Transport = &http.Transport{TLSClientConfig: o.tls.clientConfig(peerHost(baseURL))}
+	}
+	return &httpGetter{
+		baseURL: baseURL,
+		client:  client,
+		pg:      newPeerGuard(o),
+		auth:    o.auth,
+	}
+}
+
+// sign 为请求添加节点间的认证信息，签名覆盖请求方法和包含查询参数的 URI
+func (h *httpGetter) sign(req *http.Request) {
+	if h.auth == nil {
+		return
+	}
+	pairs := h.auth.sign(req.Method+" "+req.URL.RequestURI(), nil)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		req.Header.Set(pairs[i], pairs[i+1])
+	}
 }
 
+func (h *httpGetter) guard() *peerGuard { return h.pg }
+
+// Get 传输错误按重试策略重试，熔断器打开时直接返回传输错误
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
+	return h.GetContext(context.Background(), in, out)
+}
+
+// GetContext 同 Get，ctx 中的追踪上下文通过 traceparent 请求头传给远程节点
+func (h *httpGetter) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return h.pg.do(ctx, func() error { return h.get(ctx, in, out) })
+}
+
+func (h *httpGetter) get(ctx context.Context, in *pb.Request, out *pb.Response) error {
 	u := fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()),
 	)
-	res, err := http.Get(u)
+	if in.GetHedged() {
+		u += "?" + hedgedParam + "=1"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return err
 	}
+	injectTraceHeader(ctx, req.Header)
+	h.sign(req)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return &transportError{err: err}
+	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		// 节点前面的代理返回的错误，说明节点不可用
+		return &transportError{err: fmt.Errorf("server returned: %v", res.Status)}
+	default:
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
 
 	bytes, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+		return &transportError{err: fmt.Errorf("reading response body: %v", err)}
 	}
 
 	if err = proto.Unmarshal(bytes, out); err != nil {
@@ -109,28 +381,183 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	return nil
 }
 
-var _ PeerGetter = (*httpGetter)(nil)
+// GetStream 请求远程节点分段传输缓存值并写入 w，返回的 Response 只携带元数据
+func (h *httpGetter) GetStream(in *pb.Request, w io.Writer) (*pb.Response, error) {
+	return h.GetStreamContext(context.Background(), in, w)
+}
+
+// GetStreamContext 同 GetStream，与 Get 一样经过熔断器和重试策略，已经向 w 写出部分数据后不再重试
+func (h *httpGetter) GetStreamContext(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error) {
+	return h.pg.stream(ctx, w, func(w io.Writer) (*pb.Response, error) { return h.getStream(ctx, in, w) })
+}
 
-func (h *HTTPPOOL) Set(peers ...string) { // 实例化一个哈希算法，传入真实节点地址， 为每一个节点创造了一个方法httpGetter用于客户端从服务端发来的报文中获得缓存值
+func (h *httpGetter) getStream(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error) {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+	)
+	if in.GetHedged() {
+		u += "?" + hedgedParam + "=1"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", streamContentType)
+	injectTraceHeader(ctx, req.Header)
+	h.sign(req)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, &transportError{err: err}
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return nil, &transportError{err: fmt.Errorf("server returned: %v", res.Status)}
+	default:
+		return nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+	if res.Header.Get("Content-Type") != streamContentType {
+		return nil, fmt.Errorf("server does not support streaming")
+	}
+
+	version, _ := strconv.ParseUint(res.Header.Get(headerVersion), 10, 64)
+	loadTime, _ := strconv.ParseInt(res.Header.Get(headerLoadTime), 10, 64)
+	size, _ := strconv.ParseInt(res.Header.Get(headerSize), 10, 64)
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		return nil, &transportError{err: fmt.Errorf("reading response body: %v", err)}
+	}
+	if n != size {
+		return nil, &transportError{err: fmt.Errorf("stream truncated: got %d of %d bytes", n, size)}
+	}
+	return &pb.Response{Version: version, LoadTime: loadTime, Protocol: ProtocolVersion}, nil
+}
+
+// Invalidate 通过 DELETE 请求通知远程节点删除某个key的缓存副本
+func (h *httpGetter) Invalidate(in *pb.InvalidateRequest) error {
+	u := fmt.Sprintf(
+		"%v%v/%v?seq=%d",
+		h.baseURL,
+		url.QueryEscape(in.GetGroup()),
+		url.QueryEscape(in.GetKey()),
+		in.GetSeq(),
+	)
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	h.sign(req)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
+var (
+	_ PeerGetter      = (*httpGetter)(nil)
+	_ PeerInvalidator = (*httpGetter)(nil)
+	_ PeerStreamer    = (*httpGetter)(nil)
+	_ guarded         = (*httpGetter)(nil)
+
+	_ PeerContextGetter   = (*httpGetter)(nil)
+	_ PeerContextStreamer = (*httpGetter)(nil)
+)
+
+func (h *HTTPPOOL) Set(peers ...string) { // 实例化一个放置算法（默认一致性哈希），传入真实节点地址， 为每一个节点创造了一个方法httpGetter用于客户端从服务端发来的报文中获得缓存值
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.peers = hash.NewConsistentHash(defaultReplicas, nil)
-	h.peers.Add(peers...)
-	h.httpGetter = make(map[string]*httpGetter)
+	// 节点按名称顺序添加，保证集群中每个节点构建出的映射完全一致（例如 Jump 依赖节点的添加顺序）
+	sorted := append([]string(nil), peers...)
+	sort.Strings(sorted)
+	h.peers = h.opts.newPlacement()
+	h.peers.Add(sorted...)
+	getters := make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		h.httpGetter[peer] = &httpGetter{baseURL: peer + h.basePath} // http://节点地址peer/_geecache/
+		getters[peer] = h.getterLocked(peer)
+	}
+	h.httpGetter = getters
+}
+
+// SetWeighted 同 Set，按权重设置节点，权重越大的节点在一致性哈希环上的虚拟节点越多
+func (h *HTTPPOOL) SetWeighted(peers map[string]int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	nodes := make([]string, 0, len(peers))
+	for peer := range peers {
+		nodes = append(nodes, peer)
+	}
+	sort.Strings(nodes) // 同 Set，按名称顺序添加
+	h.peers = h.opts.newPlacement()
+	getters := make(map[string]*httpGetter, len(peers))
+	for _, peer := range nodes {
+		h.peers.AddWeighted(peer, peers[peer])
+		getters[peer] = h.getterLocked(peer)
+	}
+	h.httpGetter = getters
+}
+
+// getterLocked 返回节点已有的 httpGetter，保留其熔断器和延迟统计；没有时新建。调用方需持有 h.mu
+func (h *HTTPPOOL) getterLocked(peer string) *httpGetter {
+	if getter, ok := h.httpGetter[peer]; ok {
+		return getter
 	}
+	return newHTTPGetter(peer+h.basePath, h.opts) // http://节点地址peer/_geecache/
 }
 
 func (h *HTTPPOOL) PickPeer(key string) (PeerGetter, bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if peer := h.peers.Get(key); peer != "" && peer != h.self { // 根据key和一致性哈希算法，找到映射的真实节点地址。
-		h.Log("pick peer %s", peer)
-		return h.httpGetter[peer], true
+		h.opts.log.request("pick peer", "node", h.self, keyHash(key), "peer", peer)
+		var replica PeerGetter
+		if h.opts.hedge != nil {
+			if candidates := h.peers.GetN(key, 2); len(candidates) == 2 && candidates[1] != h.self {
+				replica = h.httpGetter[candidates[1]]
+			}
+		}
+		return newHedgedPeer(h.httpGetter[peer], replica, h.opts.hedge), true
 	}
 
 	return nil, false
 }
 
-var _ PeerPicker = (*HTTPPOOL)(nil)
+// PickSuccessor 返回放置算法给出的第二个候选节点，用于拥有者不可用时重试
+func (h *HTTPPOOL) PickSuccessor(key string) (PeerGetter, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	candidates := h.peers.GetN(key, 2)
+	if len(candidates) < 2 || candidates[1] == h.self {
+		return nil, false
+	}
+	return h.httpGetter[candidates[1]], true
+}
+
+// ListPeers 返回除自身外所有远程节点的 httpGetter
+func (h *HTTPPOOL) ListPeers() []PeerGetter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(h.httpGetter))
+	for peer, getter := range h.httpGetter {
+		if peer != h.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+var (
+	_ PeerPicker      = (*HTTPPOOL)(nil)
+	_ PeerLister      = (*HTTPPOOL)(nil)
+	_ SuccessorPicker = (*HTTPPOOL)(nil)
+)
@@ -0,0 +1,32 @@
+package tinycache
+
+import (
+	"fmt"
+	"testing"
+	"tinycache/hash"
+)
+
+// Jump 依赖节点的添加顺序，不同顺序传入的节点也要得到相同的映射
+func TestHTTPPoolPlacementOrder(t *testing.T) {
+	jump := WithPlacement(func() hash.Placement { return hash.NewJump(nil) })
+	a := NewHTTPPool("http://node1", jump)
+	b := NewHTTPPool("http://node2", jump)
+	a.Set("http://node1", "http://node2", "http://node3", "http://node4")
+	b.Set("http://node4", "http://node2", "http://node1", "http://node3")
+
+	weights := map[string]int{"http://node1": 1, "http://node2": 2, "http://node3": 1, "http://node4": 3}
+	c := NewHTTPPool("http://node3", jump)
+	d := NewHTTPPool("http://node4", jump)
+	c.SetWeighted(weights)
+	d.SetWeighted(weights)
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint("key", i)
+		if x, y := a.peers.Get(key), b.peers.Get(key); x != y {
+			t.Fatalf("Set: pools disagree on the owner of %s: %s vs %s", key, x, y)
+		}
+		if x, y := c.peers.Get(key), d.peers.Get(key); x != y {
+			t.Fatalf("SetWeighted: pools disagree on the owner of %s: %s vs %s", key, x, y)
+		}
+	}
+}
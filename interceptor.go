@@ -0,0 +1,429 @@
+package tinycache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WithUnaryInterceptors 为 gRPC 服务端添加一元拦截器，按添加的顺序由外向内执行
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.unaryServer = append(o.unaryServer, interceptors...)
+	}
+}
+
+// WithStreamInterceptors 为 gRPC 服务端添加流拦截器，按添加的顺序由外向内执行
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		o.streamServer = append(o.streamServer, interceptors...)
+	}
+}
+
+// WithClientUnaryInterceptors 为访问远程节点的 gRPC 客户端添加一元拦截器
+func WithClientUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) Option {
+	return func(o *options) {
+		o.unaryClient = append(o.unaryClient, interceptors...)
+	}
+}
+
+// WithClientStreamInterceptors 为访问远程节点的 gRPC 客户端添加流拦截器
+func WithClientStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) Option {
+	return func(o *options) {
+		o.streamClient = append(o.streamClient, interceptors...)
+	}
+}
+
+// WithRequestLogging 以 Info 级别记录每个 gRPC 请求的方法、耗时和状态码，日志由 WithLogger 配置，并按 WithLogSampling 采样
+func WithRequestLogging() Option {
+	return func(o *options) {
+		WithUnaryInterceptors(unaryLoggingInterceptor(o.log))(o)
+		WithStreamInterceptors(streamLoggingInterceptor(o.log))(o)
+	}
+}
+
+// WithRecovery 把处理 gRPC 请求时发生的 panic 转换为 codes.Internal 错误，避免整个进程退出。
+// 放在 WithRequestLogging、WithRPCMetrics 之后，日志和指标才能记录到转换后的错误
+func WithRecovery() Option {
+	return func(o *options) {
+		WithUnaryInterceptors(unaryRecoveryInterceptor(o.log))(o)
+		WithStreamInterceptors(streamRecoveryInterceptor(o.log))(o)
+	}
+}
+
+// WithRPCMetrics 把服务端和客户端每个 gRPC 方法的请求数、错误数和延迟记录到 m，m 同时由 /metrics 导出
+func WithRPCMetrics(m *RPCMetrics) Option {
+	return func(o *options) {
+		o.rpcMetrics = m
+		o.unaryServer = append(o.unaryServer, m.UnaryServerInterceptor())
+		o.streamServer = append(o.streamServer, m.StreamServerInterceptor())
+		o.unaryClient = append(o.unaryClient, m.UnaryClientInterceptor())
+		o.streamClient = append(o.streamClient, m.StreamClientInterceptor())
+	}
+}
+
+// WithBearerToken 启用节点间的令牌认证：客户端在请求中携带 token，服务端拒绝令牌不匹配的请求。
+// gRPC 和 HTTPPOOL 的节点间请求都需要认证
+func WithBearerToken(token string) Option {
+	return withPeerAuth(tokenAuth{token: token})
+}
+
+// WithHMACAuth 启用节点间的 HMAC 认证：客户端用共享密钥对方法名、时间戳、随机数和一元请求内容的摘要签名，
+// 服务端校验签名，拒绝时间戳偏差超过 hmacMaxSkew 的请求和重复使用的随机数，密钥本身不会在网络上传输。
+// 流式请求的消息不在签名范围内，需要防篡改时应同时启用 TLS
+func WithHMACAuth(secret []byte) Option {
+	return withPeerAuth(&hmacAuth{secret: secret, nonces: newNonceCache()})
+}
+
+func withPeerAuth(a peerAuth) Option {
+	return func(o *options) {
+		o.auth = a
+		o.unaryServer = append(o.unaryServer, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := verifyPeer(ctx, a, info.FullMethod, req); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		})
+		o.streamServer = append(o.streamServer, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := verifyPeer(ss.Context(), a, info.FullMethod, nil); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		})
+		o.unaryClient = append(o.unaryClient, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, a.sign(method, req)...), method, req, reply, cc, opts...)
+		})
+		o.streamClient = append(o.streamClient, func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, a.sign(method, nil)...), desc, cc, method, opts...)
+		})
+	}
+}
+
+// UnaryLoggingInterceptor 使用 slog.Default() 记录一元请求的方法、耗时和状态码
+func UnaryLoggingInterceptor() grpc.UnaryServerInterceptor {
+	return unaryLoggingInterceptor(newLogger())
+}
+
+// StreamLoggingInterceptor 使用 slog.Default() 记录流请求的方法、耗时和状态码
+func StreamLoggingInterceptor() grpc.StreamServerInterceptor {
+	return streamLoggingInterceptor(newLogger())
+}
+
+func unaryLoggingInterceptor(l *logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		l.requestAt(slog.LevelInfo, "rpc", "method", info.FullMethod, "code", status.Code(err).String(), "latency", time.Since(start))
+		return resp, err
+	}
+}
+
+func streamLoggingInterceptor(l *logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		l.requestAt(slog.LevelInfo, "rpc", "method", info.FullMethod, "code", status.Code(err).String(), "latency", time.Since(start))
+		return err
+	}
+}
+
+// UnaryRecoveryInterceptor 把一元请求处理中的 panic 转换为 codes.Internal 错误，panic 使用 slog.Default() 记录
+func UnaryRecoveryInterceptor() grpc.UnaryServerInterceptor {
+	return unaryRecoveryInterceptor(newLogger())
+}
+
+// StreamRecoveryInterceptor 把流请求处理中的 panic 转换为 codes.Internal 错误，panic 使用 slog.Default() 记录
+func StreamRecoveryInterceptor() grpc.StreamServerInterceptor {
+	return streamRecoveryInterceptor(newLogger())
+}
+
+func unaryRecoveryInterceptor(l *logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				l.get().Error("panic in rpc handler", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+				err = status.Errorf(codes.Internal, "panic: %v", r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func streamRecoveryInterceptor(l *logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				l.get().Error("panic in rpc handler", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+				err = status.Errorf(codes.Internal, "panic: %v", r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+//---------------------------------metrics---------------------------------
+
+// latencyBuckets 是延迟直方图的上界
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// MethodStats 是一个 gRPC 方法在服务端或客户端的统计信息
+type MethodStats struct {
+	Side    string          // "server" 或 "client"
+	Method  string          // 完整的方法名，例如 /tinycachepb.GroupCache/Get
+	Count   int64           // 请求数
+	Errors  int64           // 返回错误的请求数
+	Total   time.Duration   // 总耗时
+	Buckets []int64         // 耗时不超过 latencyBuckets[i] 的请求数（累计），最后一个元素为全部请求数
+	Bounds  []time.Duration // 与 Buckets 对应的上界
+}
+
+// RPCMetrics 统计每个 gRPC 方法的请求数、错误数和延迟，并发安全
+type RPCMetrics struct {
+	mu      sync.Mutex
+	methods map[[2]string]*MethodStats
+}
+
+// NewRPCMetrics 创建一个 RPCMetrics，配合 WithRPCMetrics 使用
+func NewRPCMetrics() *RPCMetrics {
+	return &RPCMetrics{methods: map[[2]string]*MethodStats{}}
+}
+
+func (m *RPCMetrics) observe(side, method string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.methods[[2]string{side, method}]
+	if !ok {
+		s = &MethodStats{Side: side, Method: method, Buckets: make([]int64, len(latencyBuckets)+1), Bounds: latencyBuckets[:]}
+		m.methods[[2]string{side, method}] = s
+	}
+	s.Count++
+	if err != nil {
+		s.Errors++
+	}
+	s.Total += d
+	for i, bound := range latencyBuckets {
+		if d <= bound {
+			s.Buckets[i]++
+		}
+	}
+	s.Buckets[len(latencyBuckets)]++
+}
+
+// Snapshot 返回当前所有方法的统计信息，按 Side、Method 排序
+func (m *RPCMetrics) Snapshot() []MethodStats {
+	m.mu.Lock()
+	stats := make([]MethodStats, 0, len(m.methods))
+	for _, s := range m.methods {
+		c := *s
+		c.Buckets = append([]int64(nil), s.Buckets...)
+		stats = append(stats, c)
+	}
+	m.mu.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Side != stats[j].Side {
+			return stats[i].Side < stats[j].Side
+		}
+		return stats[i].Method < stats[j].Method
+	})
+	return stats
+}
+
+// UnaryServerInterceptor 返回记录服务端一元请求的拦截器
+func (m *RPCMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe("server", info.FullMethod, time.Since(start), err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor 返回记录服务端流请求的拦截器
+func (m *RPCMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe("server", info.FullMethod, time.Since(start), err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor 返回记录客户端一元请求的拦截器
+func (m *RPCMetrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		m.observe("client", method, time.Since(start), err)
+		return err
+	}
+}
+
+// StreamClientInterceptor 返回记录客户端建立流耗时的拦截器
+func (m *RPCMetrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		m.observe("client", method, time.Since(start), err)
+		return stream, err
+	}
+}
+
+//---------------------------------auth---------------------------------
+
+const (
+	authHeader      = "authorization"
+	timestampHeader = "x-tinycache-timestamp"
+	nonceHeader     = "x-tinycache-nonce"
+	signatureHeader = "x-tinycache-signature"
+)
+
+// hmacMaxSkew 是 HMAC 认证允许的最大时间偏差
+var hmacMaxSkew = 5 * time.Minute
+
+// peerAuth 为节点间的请求生成认证信息，并在服务端校验。req 是一元请求的消息，流式请求为 nil
+type peerAuth interface {
+	sign(method string, req interface{}) []string // 返回附加到请求 metadata 的键值对
+	verify(md metadata.MD, method string, req interface{}) error
+}
+
+// verifyPeer 校验请求的认证信息，失败时返回 codes.Unauthenticated。
+// 健康检查由负载均衡和探针发起，不需要认证
+func verifyPeer(ctx context.Context, a peerAuth, method string, req interface{}) error {
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if err := a.verify(md, method, req); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+type tokenAuth struct {
+	token string
+}
+
+func (a tokenAuth) sign(method string, req interface{}) []string {
+	return []string{authHeader, "Bearer " + a.token}
+}
+
+func (a tokenAuth) verify(md metadata.MD, method string, req interface{}) error {
+	values := md.Get(authHeader)
+	if len(values) == 0 {
+		return fmt.Errorf("missing bearer token")
+	}
+	if subtle.ConstantTimeCompare([]byte(values[0]), []byte("Bearer "+a.token)) != 1 {
+		return fmt.Errorf("invalid bearer token")
+	}
+	return nil
+}
+
+type hmacAuth struct {
+	secret []byte
+	nonces *nonceCache // 服务端已经见过的随机数，拒绝重放
+}
+
+func (a *hmacAuth) mac(method, timestamp, nonce, digest string) string {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write([]byte(method + "\n" + timestamp + "\n" + nonce + "\n" + digest))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digest 返回一元请求消息确定性编码后的 SHA-256，流式请求返回空串
+func digest(req interface{}) (string, error) {
+	m, ok := req.(proto.Message)
+	if !ok {
+		return "", nil
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (a *hmacAuth) sign(method string, req interface{}) []string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	var b [16]byte
+	rand.Read(b[:])
+	nonce := hex.EncodeToString(b[:])
+	d, _ := digest(req) // 编码失败时请求本身也发不出去
+	return []string{timestampHeader, ts, nonceHeader, nonce, signatureHeader, a.mac(method, ts, nonce, d)}
+}
+
+func (a *hmacAuth) verify(md metadata.MD, method string, req interface{}) error {
+	ts, nonce, sig := md.Get(timestampHeader), md.Get(nonceHeader), md.Get(signatureHeader)
+	if len(ts) == 0 || len(nonce) == 0 || len(sig) == 0 {
+		return fmt.Errorf("missing signature")
+	}
+	unix, err := strconv.ParseInt(ts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp: %v", err)
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > hmacMaxSkew || skew < -hmacMaxSkew {
+		return fmt.Errorf("timestamp skew %v exceeds %v", skew, hmacMaxSkew)
+	}
+	d, err := digest(req)
+	if err != nil {
+		return fmt.Errorf("digest request: %v", err)
+	}
+	if !hmac.Equal([]byte(sig[0]), []byte(a.mac(method, ts[0], nonce[0], d))) {
+		return fmt.Errorf("invalid signature")
+	}
+	// 签名通过后才记录随机数，伪造的请求不能占满缓存
+	if !a.nonces.add(nonce[0], time.Unix(unix, 0).Add(hmacMaxSkew)) {
+		return fmt.Errorf("replayed request")
+	}
+	return nil
+}
+
+// nonceCache 记录时间戳仍在允许偏差内的请求随机数。时间戳过期的请求会被直接拒绝，对应的随机数不必再保留
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time // 随机数 -> 可以删除的时间
+	swept time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time), swept: time.Now()}
+}
+
+// add 记录随机数，随机数已经出现过时返回 false
+func (c *nonceCache) add(nonce string, expire time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.swept) > hmacMaxSkew {
+		for n, e := range c.seen {
+			if e.Before(now) {
+				delete(c.seen, n)
+			}
+		}
+		c.swept = now
+	}
+	if _, ok := c.seen[nonce]; ok {
+		return false
+	}
+	c.seen[nonce] = expire
+	return true
+}
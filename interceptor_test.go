@@ -0,0 +1,117 @@
+package tinycache
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	pb "tinycache/tinycachepb"
+)
+
+func newInterceptorGroup(name string) {
+	NewGroup(name, 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		if key == "boom" {
+			panic("getter panicked")
+		}
+		return []byte(db[key]), nil
+	}))
+}
+
+// peerClient 创建一个直接拨号 addr 的客户端
+func peerClient(addr string, opts ...Option) *Client {
+	o := defaultOptions()
+	for _, opt := range append([]Option{WithDirectDial()}, opts...) {
+		opt(&o)
+	}
+	return newClient("geecache/"+addr, o)
+}
+
+// httpPeer 创建访问 httpURL 的 httpGetter
+func httpPeer(httpURL string, opts ...Option) *httpGetter {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newHTTPGetter(httpURL+defaultPath, o)
+}
+
+func TestPeerAuth(t *testing.T) {
+	newInterceptorGroup("auth")
+	for name, auth := range map[string][2]Option{
+		"bearer": {WithBearerToken("s3cret"), WithBearerToken("wrong")},
+		"hmac":   {WithHMACAuth([]byte("s3cret")), WithHMACAuth([]byte("wrong"))},
+	} {
+		addr, httpURL := startTransports(t, auth[0])
+		in := &pb.Request{Group: "auth", Key: "Tom"}
+		if err := peerClient(addr, auth[0]).Get(in, &pb.Response{}); err != nil {
+			t.Fatalf("%s: peer with the right credentials should be accepted: %v", name, err)
+		}
+		for _, c := range []*Client{peerClient(addr, auth[1]), peerClient(addr)} {
+			if err := c.Get(in, &pb.Response{}); status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("%s: peer with wrong or missing credentials should be rejected, got %v", name, err)
+			}
+		}
+		if _, err := peerClient(addr, auth[1]).GetStream(in, &discard{}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("%s: streams should be authenticated too, got %v", name, err)
+		}
+
+		// HTTPPOOL 的节点间请求使用相同的凭据
+		inv := &pb.InvalidateRequest{Group: "auth", Key: "Tom", Seq: 1}
+		if err := httpPeer(httpURL, auth[0]).Invalidate(inv); err != nil {
+			t.Fatalf("%s: http peer with the right credentials should be accepted: %v", name, err)
+		}
+		for _, h := range []*httpGetter{httpPeer(httpURL, auth[1]), httpPeer(httpURL)} {
+			if err := h.Invalidate(inv); err == nil {
+				t.Fatalf("%s: http invalidation with wrong or missing credentials should be rejected", name)
+			}
+			if err := h.Get(in, &pb.Response{}); err == nil {
+				t.Fatalf("%s: http get with wrong or missing credentials should be rejected", name)
+			}
+		}
+	}
+}
+
+func TestHMACAuthReplay(t *testing.T) {
+	a := &hmacAuth{secret: []byte("s3cret"), nonces: newNonceCache()}
+	const method = "/tinycachepb.GroupCache/Delete"
+	req := &pb.DeleteRequest{Group: "auth", Key: "Tom"}
+	md := metadata.Pairs(a.sign(method, req)...)
+	if err := a.verify(md, method, &pb.DeleteRequest{Group: "auth", Key: "Jack"}); err == nil {
+		t.Fatalf("signature should not be valid for a different request")
+	}
+	if err := a.verify(md, method, req); err != nil {
+		t.Fatalf("signed request should be accepted: %v", err)
+	}
+	if err := a.verify(md, method, req); err == nil {
+		t.Fatalf("replayed request should be rejected")
+	}
+}
+
+func TestRecoveryAndMetrics(t *testing.T) {
+	newInterceptorGroup("recovery")
+	m := NewRPCMetrics()
+	addr, _ := startTransports(t, WithRequestLogging(), WithRPCMetrics(m), WithRecovery())
+	c := peerClient(addr, WithRPCMetrics(m))
+
+	if err := c.Get(&pb.Request{Group: "recovery", Key: "boom"}, &pb.Response{}); status.Code(err) != codes.Internal {
+		t.Fatalf("panic should be returned as codes.Internal, got %v", err)
+	}
+	if err := c.Get(&pb.Request{Group: "recovery", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatalf("server should keep serving after a panic: %v", err)
+	}
+
+	stats := m.Snapshot()
+	if len(stats) != 2 {
+		t.Fatalf("expected client and server stats for Get, got %+v", stats)
+	}
+	for _, s := range stats {
+		if s.Method != "/tinycachepb.GroupCache/Get" || s.Count != 2 || s.Errors != 1 || s.Buckets[len(s.Buckets)-1] != 2 {
+			t.Fatalf("unexpected stats %+v", s)
+		}
+	}
+}
+
+// discard 丢弃写入的数据
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
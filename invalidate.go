@@ -0,0 +1,194 @@
+package tinycache
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// invalidationWindow 失效记录的保留时间。超过这个时间的加载请求早已结束（或超时），不再需要用失效序号来判断
+const invalidationWindow = 5 * time.Minute
+
+// Invalidate 删除 key 在本节点 hotCache 和 mainCache 中的副本，并广播给所有远程节点，
+// 每个节点收到后同样删除自己的副本。更新数据源中的值后调用 Invalidate，可以避免集群中残留旧数据。
+// 每条失效消息带有单调递增的序号：失效之前发起的加载不会再把旧值写回缓存，迟到或重复的失效消息会被忽略。
+func (g *Group) Invalidate(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	seq := g.nextSeq()
+	g.applyInvalidation(key, seq)
+	return g.invalidatePeers(key, seq)
+}
+
+// invalidatePeers 把序号为 seq 的失效消息广播给其他节点，返回第一个失败节点的错误
+func (g *Group) invalidatePeers(key string, seq uint64) error {
+	lister, ok := g.peers.(PeerLister)
+	if !ok {
+		return nil
+	}
+	req := &pb.InvalidateRequest{Group: g.name, Key: key, Seq: seq}
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	for _, peer := range lister.ListPeers() {
+		invalidator, ok := peer.(PeerInvalidator)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(invalidator PeerInvalidator) {
+			defer wg.Done()
+			if err := invalidator.Invalidate(req); err != nil {
+				g.log.get().Warn("invalidate on peer failed", "group", g.name, keyHash(key), "err", err)
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errMu.Unlock()
+			}
+		}(invalidator)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// maxSeqAhead 远程节点发来的失效序号最多可以领先本地时钟多久。序号是纳秒时间戳，
+// 领先太多的序号会让之后正常的失效和写入都被当成旧消息忽略
+const maxSeqAhead = time.Minute
+
+// applyRemoteInvalidation 应用远程节点发来的失效消息，拒绝领先本地时钟超过 maxSeqAhead 的序号
+func (g *Group) applyRemoteInvalidation(key string, seq uint64) error {
+	if limit := uint64(time.Now().Add(maxSeqAhead).UnixNano()); seq > limit {
+		return fmt.Errorf("seq %d is too far ahead of the local clock", seq)
+	}
+	g.applyInvalidation(key, seq)
+	return nil
+}
+
+// invalidations 记录每个key最近一次失效的序号。失效序号和缓存值的版本号来自同一个时钟，可以直接比较；
+// mu 同时保护所有按版本号写入缓存的操作
+type invalidations struct {
+	mu    sync.Mutex
+	clock uint64            // 最大的已知序号，保证生成的序号单调递增
+	seqs  map[string]uint64 // key -> 最近一次失效的序号
+	all   uint64            // 最近一次清空整个 Group（Flush）的序号，对所有key生效
+}
+
+// lockInval 锁住 g.inval.mu，返回解锁函数。持锁期间缓存的删除事件被暂存，解锁之后才通知 OnEvict，
+// 这样监听者可以调用同一个 Group 的方法，不会在 g.inval.mu 上死锁
+func (g *Group) lockInval() (unlock func()) {
+	release := g.holdEvictions()
+	g.inval.mu.Lock()
+	return func() {
+		g.inval.mu.Unlock()
+		release()
+	}
+}
+
+// holdEvictions 暂停通知 mainCache 和 hotCache 的删除事件，返回的函数恢复通知并通知暂停期间的事件
+func (g *Group) holdEvictions() (release func()) {
+	main, hot := g.mainCache.counters(), g.hotCache.counters()
+	main.hold()
+	hot.hold()
+	return func() {
+		main.release()
+		hot.release()
+	}
+}
+
+// nextSeq 生成新的失效序号（或版本号）。序号取当前纳秒时间戳与已知最大序号+1中的较大者，
+// 这样不同节点生成的序号大致按时间排序，同一节点生成的序号严格递增
+func (g *Group) nextSeq() uint64 {
+	g.inval.mu.Lock()
+	defer g.inval.mu.Unlock()
+	return g.nextSeqLocked()
+}
+
+// nextSeqLocked 同 nextSeq，调用方需持有 g.inval.mu
+func (g *Group) nextSeqLocked() uint64 {
+	seq := uint64(time.Now().UnixNano())
+	if seq <= g.inval.clock {
+		seq = g.inval.clock + 1
+	}
+	g.inval.clock = seq
+	return seq
+}
+
+// applyInvalidation 应用一条失效消息，删除本地的副本。序号不大于已记录的序号说明是重复或迟到的消息，返回 false
+func (g *Group) applyInvalidation(key string, seq uint64) bool {
+	defer g.lockInval()()
+	if g.inval.seqs == nil {
+		g.inval.seqs = make(map[string]uint64)
+	}
+	if seq <= g.inval.seqs[key] {
+		return false
+	}
+	g.inval.seqs[key] = seq
+	if seq > g.inval.clock {
+		g.inval.clock = seq
+	}
+	g.hotCache.remove(key)
+	g.mainCache.remove(key)
+	leases.forget(g.name, key)
+	g.pruneInvalidations()
+	return true
+}
+
+// invalidatedAfter 判断 key 是否在 version 之后被失效过
+func (g *Group) invalidatedAfter(key string, version uint64) bool {
+	g.inval.mu.Lock()
+	defer g.inval.mu.Unlock()
+	return g.invalidatedLocked(key, version)
+}
+
+// invalidatedLocked 同 invalidatedAfter，调用方需持有 g.inval.mu
+func (g *Group) invalidatedLocked(key string, version uint64) bool {
+	return g.inval.seqs[key] > version || g.inval.all > version
+}
+
+// populateVersioned 按版本号把数据写入缓存，保证并发写入和失效的结果是确定的：
+//   - key 在 value 的版本之后被失效过，不写入，这样失效之前发起的加载不会把旧值写回缓存
+//   - 缓存中已有更新的版本，不写入，版本相同时保留字节序更大的值
+//
+// hot 为 true 时写入 hotCache，否则写入 mainCache
+func (g *Group) populateVersioned(key string, value ByteView, hot bool) bool {
+	defer g.lockInval()()
+	return g.populateLocked(key, value, hot, 0)
+}
+
+// populateLocked 同 populateVersioned，ttl 为写入 mainCache 时的过期时间（<=0 使用默认值），调用方需持有 g.inval.mu
+func (g *Group) populateLocked(key string, value ByteView, hot bool, ttl time.Duration) bool {
+	if g.invalidatedLocked(key, value.version) {
+		return false
+	}
+	cache := g.mainCache
+	if hot {
+		cache = g.hotCache
+	}
+	if cur, ok := cache.get(key); ok && !value.newerThan(cur) {
+		return false
+	}
+	if hot {
+		g.populateHotCache(key, value)
+	} else {
+		g.populateCache(key, value, ttl)
+	}
+	return true
+}
+
+// pruneInvalidations 清理超过 invalidationWindow 的失效记录，调用方需持有 g.inval.mu
+func (g *Group) pruneInvalidations() {
+	if len(g.inval.seqs) < 1024 {
+		return
+	}
+	deadline := uint64(time.Now().Add(-invalidationWindow).UnixNano())
+	for key, seq := range g.inval.seqs {
+		if seq < deadline {
+			delete(g.inval.seqs, key)
+		}
+	}
+}
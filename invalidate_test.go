@@ -0,0 +1,112 @@
+package tinycache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// fakePeers 只用于广播失效消息，PickPeer 总是返回自身
+type fakePeers struct {
+	mu   sync.Mutex
+	reqs []*pb.InvalidateRequest
+}
+
+func (p *fakePeers) PickPeer(key string) (PeerGetter, bool) { return nil, false }
+
+func (p *fakePeers) ListPeers() []PeerGetter { return []PeerGetter{p} }
+
+func (p *fakePeers) Get(in *pb.Request, out *pb.Response) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (p *fakePeers) Invalidate(in *pb.InvalidateRequest) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reqs = append(p.reqs, in)
+	return nil
+}
+
+func TestInvalidate(t *testing.T) {
+	loads := 0
+	g := NewGroup("invalidate", 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	peers := &fakePeers{}
+	g.RegisterPeers(peers)
+
+	g.Get("Tom")
+	if err := g.Invalidate("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if len(peers.reqs) != 1 || peers.reqs[0].Key != "Tom" || peers.reqs[0].Seq == 0 {
+		t.Fatalf("invalidation should be broadcast to peers, got %v", peers.reqs)
+	}
+	if g.Get("Tom"); loads != 2 {
+		t.Fatalf("invalidated key should be loaded again, loads=%d", loads)
+	}
+
+	// 迟到的失效消息不会再次删除缓存
+	if g.applyInvalidation("Tom", peers.reqs[0].Seq) {
+		t.Fatalf("duplicate invalidation should be ignored")
+	}
+	if g.Get("Tom"); loads != 2 {
+		t.Fatalf("stale invalidation should not evict, loads=%d", loads)
+	}
+}
+
+// 失效之前发起的加载不能把旧值写回缓存
+func TestInvalidateDuringLoad(t *testing.T) {
+	loading, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("invalidate-inflight", 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			close(loading)
+			<-release
+			return []byte("stale"), nil
+		}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if v, err := g.Get("Tom"); err != nil || v.String() != "stale" {
+			t.Errorf("in-flight load should still return its value, got %v %v", v, err)
+		}
+	}()
+	<-loading
+	g.Invalidate("Tom")
+	close(release)
+	<-done
+
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("value loaded before invalidation should not be cached")
+	}
+}
+
+// 领先本地时钟太多的失效序号会让之后的失效都被忽略，必须拒绝
+func TestRemoteInvalidationAhead(t *testing.T) {
+	g := NewGroup("invalidate-ahead", 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	_, httpURL := startTransports(t)
+	getter := newHTTPGetter(httpURL+defaultPath, defaultOptions())
+
+	g.Get("Tom")
+	ahead := uint64(time.Now().Add(time.Hour).UnixNano())
+	if err := getter.Invalidate(&pb.InvalidateRequest{Group: g.name, Key: "Tom", Seq: ahead}); err == nil {
+		t.Fatalf("seq far ahead of the local clock should be rejected")
+	}
+	if _, ok := g.mainCache.get("Tom"); !ok {
+		t.Fatalf("rejected invalidation should not evict")
+	}
+	if err := getter.Invalidate(&pb.InvalidateRequest{Group: g.name, Key: "Tom", Seq: g.nextSeq()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("invalidation should evict the key")
+	}
+}
@@ -0,0 +1,197 @@
+package tinycache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+var (
+	leaseTTL       = 3 * time.Second        // 租约的有效期，持有者宕机时租约到期后自动失效
+	leaseResultTTL = time.Second            // 加载结果在拥有者处保留的时间，供等待中的节点直接取用
+	leaseWait      = 5 * time.Second        // 申请者最多等待多久，超时后直接加载数据源
+	leasePoll      = 50 * time.Millisecond  // 服务端建议的重试间隔
+	leaseLongPoll  = 500 * time.Millisecond // 每次申请时在服务端最多等待的时间
+)
+
+// fillLease 是一个key的加载租约
+type fillLease struct {
+	token  uint64
+	expire time.Time     // 租约（或加载结果）的过期时间
+	filled bool          // 是否已经加载完成
+	value  *pb.Response  // 加载结果
+	done   chan struct{} // 租约被归还或过期时关闭，唤醒等待中的申请者
+}
+
+// leaseTable 由key的拥有者维护，保证同一时刻整个集群只有一个节点在为同一个key加载数据源，
+// 其他节点等待或轮询加载结果，避免拥有者不可用时所有节点同时访问数据库。
+type leaseTable struct {
+	mu     sync.Mutex
+	next   uint64
+	leases map[string]*fillLease // group/key -> 租约
+}
+
+// leases 是进程内唯一的租约表，Server 的 RPC 和拥有者自身的加载共用
+var leases = &leaseTable{leases: make(map[string]*fillLease)}
+
+// acquire 申请租约：没有有效租约时发放新租约；已有加载结果时直接返回结果；
+// 否则在 wait 时间内等待租约被归还，仍未归还则告诉申请者稍后重试
+func (t *leaseTable) acquire(in *pb.LeaseRequest) *pb.LeaseResponse {
+	id := in.GetGroup() + "/" + in.GetKey()
+	deadline := time.Now().Add(time.Duration(in.GetWaitMs()) * time.Millisecond)
+	for {
+		t.mu.Lock()
+		now := time.Now()
+		l, ok := t.leases[id]
+		if ok && l.filled && now.Before(l.expire) {
+			t.mu.Unlock()
+			return &pb.LeaseResponse{Filled: true, Value: l.value.Value, Version: l.value.Version, LoadTime: l.value.LoadTime}
+		}
+		if !ok || !now.Before(l.expire) { // 没有租约，加载结果已过期，或持有者没有按时归还（可能已宕机）
+			if ok && !l.filled { // 已加载完成的租约在归还时已经关闭了 done
+				close(l.done)
+			}
+			t.next++
+			token := t.next // 解锁后 t.next 可能被其他申请者修改
+			t.leases[id] = &fillLease{token: token, expire: now.Add(leaseTTL), done: make(chan struct{})}
+			t.prune(now)
+			t.mu.Unlock()
+			return &pb.LeaseResponse{Granted: true, Token: token}
+		}
+		done, expire := l.done, l.expire
+		t.mu.Unlock()
+
+		wait := deadline.Sub(now)
+		if wait <= 0 {
+			retry := expire.Sub(now)
+			if retry > leasePoll {
+				retry = leasePoll
+			}
+			return &pb.LeaseResponse{RetryAfterMs: retry.Milliseconds() + 1}
+		}
+		if untilExpire := expire.Sub(now); untilExpire < wait {
+			wait = untilExpire
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-done:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// release 归还租约。加载成功时保存结果供等待者取用；加载失败或加载期间key被失效时删除租约，让下一个申请者立即重试
+func (t *leaseTable) release(in *pb.ReleaseRequest) {
+	id := in.GetGroup() + "/" + in.GetKey()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.leases[id]
+	if !ok || l.token != in.GetToken() || l.filled {
+		return // 租约已过期并被其他节点拿走
+	}
+	close(l.done)
+	if g := GetGroup(in.GetGroup()); in.GetFailed() || (g != nil && g.invalidatedAfter(in.GetKey(), in.GetVersion())) {
+		delete(t.leases, id)
+		return
+	}
+	l.filled = true
+	l.value = &pb.Response{Value: in.GetValue(), Version: in.GetVersion(), LoadTime: in.GetLoadTime()}
+	l.expire = time.Now().Add(leaseResultTTL)
+}
+
+// forget 删除key已经完成的加载结果，key 被失效后等待者不能再拿到旧值
+func (t *leaseTable) forget(group, key string) {
+	id := group + "/" + key
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if l, ok := t.leases[id]; ok && l.filled {
+		delete(t.leases, id)
+	}
+}
+
+// forgetGroup 删除一个 Group 的所有租约，唤醒等待中的申请者
+func (t *leaseTable) forgetGroup(group string) {
+	prefix := group + "/"
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, l := range t.leases {
+		if strings.HasPrefix(id, prefix) {
+			if !l.filled {
+				close(l.done)
+			}
+			delete(t.leases, id)
+		}
+	}
+}
+
+// prune 清理已过期的租约，调用方需持有 t.mu
+func (t *leaseTable) prune(now time.Time) {
+	if len(t.leases) < 1024 {
+		return
+	}
+	for id, l := range t.leases {
+		if !now.Before(l.expire) {
+			if !l.filled {
+				close(l.done)
+			}
+			delete(t.leases, id)
+		}
+	}
+}
+
+// AcquireLease 实现 PeerLeaser，直接访问本进程的租约表，用于拥有者自身加载数据源
+func (t *leaseTable) AcquireLease(in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	return t.acquire(in), nil
+}
+
+// ReleaseLease 实现 PeerLeaser，直接访问本进程的租约表
+func (t *leaseTable) ReleaseLease(in *pb.ReleaseRequest) error {
+	t.release(in)
+	return nil
+}
+
+var _ PeerLeaser = (*leaseTable)(nil)
+
+// loadWithLease 先向 leaser 申请加载租约：拿到租约则加载数据源并把结果交给 leaser；
+// 其他节点已加载完成则直接使用其结果；租约被占用则等待或轮询，直到超过 leaseWait 或 ctx 结束。
+// leaser 不可达或等待超时时，退化为直接加载数据源。populate 为 true 时加载结果写入 mainCache
+func (g *Group) loadWithLease(ctx context.Context, leaser PeerLeaser, key string, populate bool) (ByteView, error) {
+	deadline := time.Now().Add(leaseWait)
+	for {
+		res, err := leaser.AcquireLease(&pb.LeaseRequest{Group: g.name, Key: key, WaitMs: leaseLongPoll.Milliseconds()})
+		if err != nil {
+			return g.getLocally(ctx, key, populate)
+		}
+		if res.Filled {
+			value := ByteView{b: res.Value, version: res.Version, loadedAt: time.Unix(0, res.LoadTime)}
+			if populate { // 例如自己是拥有者，其他节点加载的结果同样写入 mainCache
+				g.populateVersioned(key, value, false)
+			}
+			return value, nil
+		}
+		if res.Granted {
+			value, err := g.getLocally(ctx, key, populate)
+			release := &pb.ReleaseRequest{Group: g.name, Key: key, Token: res.Token, Failed: err != nil}
+			if err == nil {
+				release.Value = value.b
+				release.Version = value.version
+				release.LoadTime = value.loadedAt.UnixNano()
+			}
+			leaser.ReleaseLease(release)
+			return value, err
+		}
+		if time.Now().After(deadline) {
+			return g.getLocally(ctx, key, populate)
+		}
+		timer := time.NewTimer(time.Duration(res.RetryAfterMs) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ByteView{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package tinycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+func TestLeaseTable(t *testing.T) {
+	table := &leaseTable{leases: make(map[string]*fillLease)}
+	req := &pb.LeaseRequest{Group: "scores", Key: "Tom"}
+
+	first := table.acquire(req)
+	if !first.Granted {
+		t.Fatalf("first requester should be granted the lease")
+	}
+	if second := table.acquire(req); second.Granted || second.RetryAfterMs <= 0 {
+		t.Fatalf("second requester should be asked to retry, got %v", second)
+	}
+
+	// 等待中的申请者在租约归还后拿到加载结果
+	done := make(chan *pb.LeaseResponse)
+	go func() {
+		done <- table.acquire(&pb.LeaseRequest{Group: "scores", Key: "Tom", WaitMs: 1000})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	table.release(&pb.ReleaseRequest{Group: "scores", Key: "Tom", Token: first.Token, Value: []byte("630")})
+	if res := <-done; !res.Filled || string(res.Value) != "630" {
+		t.Fatalf("waiter should receive the filled value, got %v", res)
+	}
+}
+
+// 并发申请不同key的租约，每个申请者拿到的令牌都不同，且能用它归还租约
+func TestLeaseConcurrentAcquire(t *testing.T) {
+	table := &leaseTable{leases: make(map[string]*fillLease)}
+	const n = 64
+	tokens := make([]uint64, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res := table.acquire(&pb.LeaseRequest{Group: "scores", Key: fmt.Sprint(i)})
+			if !res.Granted {
+				t.Errorf("lease for a new key should be granted")
+			}
+			tokens[i] = res.Token
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint64]bool, n)
+	for i, token := range tokens {
+		if seen[token] {
+			t.Fatalf("token %d was returned twice", token)
+		}
+		seen[token] = true
+		table.release(&pb.ReleaseRequest{Group: "scores", Key: fmt.Sprint(i), Token: token, Value: []byte("630")})
+		if res := table.acquire(&pb.LeaseRequest{Group: "scores", Key: fmt.Sprint(i)}); !res.Filled {
+			t.Fatalf("release with the returned token should fill the lease, got %v", res)
+		}
+	}
+}
+
+func TestLeaseExpire(t *testing.T) {
+	defer func(ttl time.Duration) { leaseTTL = ttl }(leaseTTL)
+	leaseTTL = 20 * time.Millisecond
+
+	table := &leaseTable{leases: make(map[string]*fillLease)}
+	req := &pb.LeaseRequest{Group: "scores", Key: "Tom"}
+	dead := table.acquire(req) // 持有者拿到租约后宕机，不再归还
+	time.Sleep(2 * leaseTTL)
+	res := table.acquire(req)
+	if !res.Granted || res.Token == dead.Token {
+		t.Fatalf("expired lease should be granted to the next requester")
+	}
+	// 过期的持有者归还租约不会影响新的持有者
+	table.release(&pb.ReleaseRequest{Group: "scores", Key: "Tom", Token: dead.Token, Value: []byte("stale")})
+	if again := table.acquire(req); again.Granted || again.Filled {
+		t.Fatalf("stale release should be ignored, got %v", again)
+	}
+}
+
+// 加载结果过期后重新发放租约
+func TestLeaseResultExpire(t *testing.T) {
+	defer func(ttl time.Duration) { leaseResultTTL = ttl }(leaseResultTTL)
+	leaseResultTTL = 10 * time.Millisecond
+
+	table := &leaseTable{leases: make(map[string]*fillLease)}
+	req := &pb.LeaseRequest{Group: "scores", Key: "Tom"}
+	first := table.acquire(req)
+	table.release(&pb.ReleaseRequest{Group: "scores", Key: "Tom", Token: first.Token, Value: []byte("630")})
+	time.Sleep(2 * leaseResultTTL)
+	if res := table.acquire(req); !res.Granted || res.Token == first.Token {
+		t.Fatalf("expired result should be replaced by a new lease, got %v", res)
+	}
+}
+
+// 多个请求同时回退到数据源时，只有一个真正访问数据源
+func TestLoadWithLease(t *testing.T) {
+	var loads int32
+	g := NewGroup("lease", 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			time.Sleep(20 * time.Millisecond)
+			return []byte(db[key]), nil
+		}))
+	table := &leaseTable{leases: make(map[string]*fillLease)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.loadWithLease(context.Background(), table, "Tom", true); err != nil || v.String() != "630" {
+				t.Errorf("expected 630, got %v %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("source should be loaded once, got %d", loads)
+	}
+}
+
+// retryLeaser 总是让申请者稍后重试
+type retryLeaser struct{}
+
+func (retryLeaser) AcquireLease(in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	return &pb.LeaseResponse{RetryAfterMs: 1000}, nil
+}
+
+func (retryLeaser) ReleaseLease(in *pb.ReleaseRequest) error { return nil }
+
+func TestLoadWithLeaseContext(t *testing.T) {
+	g := NewGroup("lease-ctx", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := g.loadWithLease(ctx, retryLeaser{}, "Tom", false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("lease wait should stop when ctx is done, took %v", time.Since(start))
+	}
+}
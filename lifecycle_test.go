@@ -0,0 +1,123 @@
+package tinycache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// checkGoroutines 等待协程数回落到 before 以下，超时则报告泄漏
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutine leak: %d > %d\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// serveSlow 启动一个 gRPC 服务，访问 group 时数据源耗时 delay
+func serveSlow(t *testing.T, group string, delay time.Duration) (*Server, string, chan error) {
+	NewGroup(group, 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		time.Sleep(delay)
+		return []byte(db[key]), nil
+	}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svr, _ := NewServer(lis.Addr().String())
+	served := make(chan error, 1)
+	go func() { served <- svr.Serve(lis) }()
+	return svr, lis.Addr().String(), served
+}
+
+func TestServerShutdownDrains(t *testing.T) {
+	before := runtime.NumGoroutine()
+	svr, addr, served := serveSlow(t, "shutdown-drain", 200*time.Millisecond)
+	defer RemoveGroup("shutdown-drain")
+	c := peerClient(addr)
+
+	inflight := make(chan error, 1)
+	go func() {
+		inflight <- c.Get(&pb.Request{Group: "shutdown-drain", Key: "Tom"}, &pb.Response{})
+	}()
+	time.Sleep(50 * time.Millisecond) // 等请求到达服务端
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-inflight; err != nil {
+		t.Fatalf("in-flight request should complete during graceful shutdown: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve should return nil after shutdown, got %v", err)
+	}
+	if err := c.Get(&pb.Request{Group: "shutdown-drain", Key: "Tom"}, &pb.Response{}); !IsTransportError(err) {
+		t.Fatalf("requests after shutdown should fail with a transport error, got %v", err)
+	}
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatalf("second shutdown should be a no-op, got %v", err)
+	}
+	checkGoroutines(t, before)
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	before := runtime.NumGoroutine()
+	svr, addr, served := serveSlow(t, "shutdown-deadline", time.Second)
+	defer RemoveGroup("shutdown-deadline")
+
+	go peerClient(addr).Get(&pb.Request{Group: "shutdown-deadline", Key: "Tom"}, &pb.Response{})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := svr.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown should give up when ctx expires, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("shutdown should not wait for in-flight requests after ctx expires, took %v", time.Since(start))
+	}
+	<-served
+	time.Sleep(time.Second) // 等被中断的数据源调用返回
+	checkGoroutines(t, before)
+}
+
+func TestGroupClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	s := newStore()
+	g := NewGroup("close", 2<<10, "lru", s, WithWriteBehind(s, WriteBehindConfig{FlushInterval: time.Hour}))
+	if _, err := g.Set("Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if !RemoveGroup("close") {
+		t.Fatalf("RemoveGroup should find the group")
+	}
+	if v, _ := s.Get("Tom"); string(v) != "700" {
+		t.Fatalf("pending writes should be flushed on close, got %q", v)
+	}
+	if GetGroup("close") != nil || RemoveGroup("close") {
+		t.Fatalf("closed group should be removed from the registry")
+	}
+	if _, ok := g.mainCache.get("Tom"); ok {
+		t.Fatalf("closed group should release its cache")
+	}
+	g.Close() // 可以重复调用
+	checkGoroutines(t, before)
+
+	g = NewGroup("close", 2<<10, "lru", s)
+	defer g.Close()
+	if v, err := g.Get("Tom"); err != nil || v.String() != "700" {
+		t.Fatalf("group should be recreatable with the same name, got %v %v", v, err)
+	}
+}
@@ -0,0 +1,85 @@
+package tinycache
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+)
+
+// logger 包装 slog.Logger，并对每个请求都会产生的日志按 sampleEvery 采样
+type logger struct {
+	l           *slog.Logger // 为 nil 时使用 slog.Default()，之后调用 slog.SetDefault 同样生效
+	sampleEvery uint64       // 每 sampleEvery 条请求日志记录一条，<=1 表示全部记录
+	n           uint64       // 请求日志的计数，原子访问
+}
+
+func newLogger() *logger {
+	return &logger{}
+}
+
+func (l *logger) get() *slog.Logger {
+	if l.l != nil {
+		return l.l
+	}
+	return slog.Default()
+}
+
+// sampled 判断这一条请求日志是否应该记录
+func (l *logger) sampled() bool {
+	return l.sampleEvery <= 1 || atomic.AddUint64(&l.n, 1)%l.sampleEvery == 1
+}
+
+// request 记录每个请求都会产生的日志（例如命中缓存、选择节点），级别为 Debug，并按配置采样
+func (l *logger) request(msg string, args ...any) {
+	l.requestAt(slog.LevelDebug, msg, args...)
+}
+
+// requestAt 同 request，按指定的级别记录
+func (l *logger) requestAt(level slog.Level, msg string, args ...any) {
+	lg := l.get()
+	if !lg.Enabled(context.Background(), level) || !l.sampled() {
+		return
+	}
+	lg.Log(context.Background(), level, msg, args...)
+}
+
+// keyHash 返回 key 的哈希，日志中用它代替 key，避免记录敏感数据并控制日志体积
+func keyHash(key string) slog.Attr {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return slog.String("key_hash", strconv.FormatUint(uint64(h.Sum32()), 16))
+}
+
+// WithLogger 设置 Server 或 HTTPPOOL 使用的日志，默认为 slog.Default()。每个请求都会产生的日志级别为 Debug
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.log.l = l
+	}
+}
+
+// WithLogSampling 对 Server 或 HTTPPOOL 每个请求都会产生的日志采样，每 every 条只记录一条
+func WithLogSampling(every int) Option {
+	return func(o *options) {
+		if every > 0 {
+			o.log.sampleEvery = uint64(every)
+		}
+	}
+}
+
+// WithGroupLogger 设置 Group 使用的日志，默认为 slog.Default()。命中缓存、加载数据等每个请求都会产生的日志级别为 Debug
+func WithGroupLogger(l *slog.Logger) GroupOption {
+	return func(g *Group) {
+		g.log.l = l
+	}
+}
+
+// WithGroupLogSampling 对 Group 每个请求都会产生的日志采样，每 every 条只记录一条
+func WithGroupLogSampling(every int) GroupOption {
+	return func(g *Group) {
+		if every > 0 {
+			g.log.sampleEvery = uint64(every)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tinycache
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	pb "tinycache/tinycachepb"
+)
+
+func TestGroupLogging(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	g := NewGroup("logging", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithGroupLogger(l), WithGroupLogSampling(2))
+
+	for i := 0; i < 5; i++ {
+		g.Get("Tom")
+	}
+	logs := buf.String()
+	if n := strings.Count(logs, `"msg":"cache hit"`); n != 2 {
+		t.Fatalf("4 hits sampled 1 in 2 should log twice, got %d:\n%s", n, logs)
+	}
+	if !strings.Contains(logs, `"group":"logging"`) || !strings.Contains(logs, `"key_hash":`) || strings.Contains(logs, "Tom") {
+		t.Fatalf("logs should carry the group and key hash instead of the key:\n%s", logs)
+	}
+}
+
+func TestRequestLogsSilentByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil))) // 默认级别为 Info
+
+	g := NewGroup("logging-default", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	g.Get("Tom")
+	g.Get("Tom")
+	pool := NewHTTPPool("http://127.0.0.1")
+	pool.Set("http://127.0.0.1", "http://127.0.0.2")
+	pool.PickPeer("Tom")
+	if buf.Len() != 0 {
+		t.Fatalf("per-request logs should be disabled at the default level, got:\n%s", buf.String())
+	}
+}
+
+func TestServerRequestLogging(t *testing.T) {
+	NewGroup("logging-rpc", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	addr, _ := startTransports(t, WithLogger(l), WithRequestLogging())
+	if err := peerClient(addr).Get(&pb.Request{Group: "logging-rpc", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	logs := buf.String()
+	if !strings.Contains(logs, "msg=rpc method=/tinycachepb.GroupCache/Get code=OK latency=") {
+		t.Fatalf("request logging should use the configured logger, got:\n%s", logs)
+	}
+	if strings.Contains(logs, "recv rpc request") {
+		t.Fatalf("debug logs should be filtered by the logger level, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,164 @@
+package tinycache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsPath 是 HTTPPOOL 导出 Prometheus 指标的路径
+const metricsPath = "/metrics"
+
+// histogram 是按 latencyBuckets 分桶的延迟直方图，零值可用，并发安全
+type histogram struct {
+	counts [len(latencyBuckets) + 1]AtomicInt // counts[i] 为落在第 i 个桶中的次数（不累计），最后一个桶为超过所有上界的次数
+	sum    AtomicInt                          // 总耗时，单位纳秒
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// nodeMetrics 是节点级别的指标，由 Server 和 HTTPPOOL 提供
+type nodeMetrics struct {
+	node  string                // 本节点地址，作为 node 标签
+	ring  int                   // 放置算法中的节点数，包括自己
+	peers map[string]*histogram // 访问每个远程节点的耗时
+	rpc   *RPCMetrics           // gRPC 方法的统计，可以为 nil
+}
+
+// metricsHandler 返回以 Prometheus 文本格式导出所有 Group 和 collect 返回的节点指标的 http.Handler
+func metricsHandler(collect func() nodeMetrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, collect())
+	})
+}
+
+// writeMetrics 按 Prometheus 文本格式写出指标，同名指标的样本连续输出
+func writeMetrics(w io.Writer, n nodeMetrics) {
+	groups := allGroups()
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	stats := make([]GroupStats, len(groups))
+	for i, g := range groups {
+		stats[i] = g.Stats()
+	}
+	node := label("node", n.node)
+	e := &exposition{w: bufio.NewWriter(w)}
+	defer e.w.Flush()
+
+	groupCounter := func(name, help string, value func(s GroupStats) int64) {
+		e.family(name, help, "counter")
+		for _, s := range stats {
+			e.sample(name, float64(value(s)), label("group", s.Name), node)
+		}
+	}
+	groupCounter("tinycache_gets_total", "Number of Get requests.", func(s GroupStats) int64 { return s.Gets })
+	groupCounter("tinycache_misses_total", "Number of Get requests that missed both hot and main cache.", func(s GroupStats) int64 {
+		return s.Gets - s.HotHits - s.MainHits
+	})
+	groupCounter("tinycache_peer_loads_total", "Number of values loaded from remote peers.", func(s GroupStats) int64 { return s.PeerLoads })
+	groupCounter("tinycache_peer_errors_total", "Number of failed loads from remote peers.", func(s GroupStats) int64 { return s.PeerErrors })
+	groupCounter("tinycache_local_loads_total", "Number of values loaded from the data source.", func(s GroupStats) int64 { return s.LocalLoads })
+	groupCounter("tinycache_local_load_errors_total", "Number of failed loads from the data source.", func(s GroupStats) int64 { return s.LocalLoadErrors })
+	groupCounter("tinycache_loads_deduped_total", "Number of loads merged into a concurrent load of the same key.", func(s GroupStats) int64 { return s.LoadsDeduped })
+
+	cacheMetric := func(name, help, typ string, value func(c CacheStats) int64) {
+		e.family(name, help, typ)
+		for _, s := range stats {
+			e.sample(name, float64(value(s.MainCache)), label("group", s.Name), node, label("cache", "main"))
+			e.sample(name, float64(value(s.HotCache)), label("group", s.Name), node, label("cache", "hot"))
+		}
+	}
+	e.family("tinycache_hits_total", "Number of Get requests served from the cache.", "counter")
+	for _, s := range stats {
+		e.sample("tinycache_hits_total", float64(s.MainHits), label("group", s.Name), node, label("cache", "main"))
+		e.sample("tinycache_hits_total", float64(s.HotHits), label("group", s.Name), node, label("cache", "hot"))
+	}
+	cacheMetric("tinycache_evictions_total", "Number of entries evicted for capacity.", "counter", func(c CacheStats) int64 { return c.Evictions })
+	cacheMetric("tinycache_expirations_total", "Number of entries removed after their TTL.", "counter", func(c CacheStats) int64 { return c.Expirations })
+	cacheMetric("tinycache_cache_items", "Number of unexpired entries in the cache.", "gauge", func(c CacheStats) int64 { return c.Items })
+	cacheMetric("tinycache_cache_bytes", "Bytes of keys and values of unexpired entries in the cache.", "gauge", func(c CacheStats) int64 { return c.Bytes })
+
+	e.family("tinycache_load_duration_seconds", "Latency of loading a missed key, by source.", "histogram")
+	for _, g := range groups {
+		e.histogram("tinycache_load_duration_seconds", &g.stats.localLoadLatency, label("group", g.name), node, label("source", "local"))
+		e.histogram("tinycache_load_duration_seconds", &g.stats.peerLoadLatency, label("group", g.name), node, label("source", "peer"))
+	}
+
+	e.family("tinycache_ring_peers", "Number of nodes in the placement, including this node.", "gauge")
+	e.sample("tinycache_ring_peers", float64(n.ring), node)
+
+	peers := make([]string, 0, len(n.peers))
+	for peer := range n.peers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	e.family("tinycache_peer_request_duration_seconds", "Latency of Get requests to each remote peer, including failed attempts.", "histogram")
+	for _, peer := range peers {
+		e.histogram("tinycache_peer_request_duration_seconds", n.peers[peer], node, label("peer", peer))
+	}
+
+	if n.rpc == nil {
+		return
+	}
+	methods := n.rpc.Snapshot()
+	e.family("tinycache_rpc_errors_total", "Number of gRPC calls that returned an error.", "counter")
+	for _, m := range methods {
+		e.sample("tinycache_rpc_errors_total", float64(m.Errors), node, label("side", m.Side), label("method", m.Method))
+	}
+	e.family("tinycache_rpc_duration_seconds", "Latency of gRPC calls.", "histogram")
+	for _, m := range methods {
+		labels := []string{node, label("side", m.Side), label("method", m.Method)}
+		for i, bound := range m.Bounds {
+			e.sample("tinycache_rpc_duration_seconds_bucket", float64(m.Buckets[i]), append(labels, label("le", seconds(bound)))...)
+		}
+		e.sample("tinycache_rpc_duration_seconds_bucket", float64(m.Count), append(labels, label("le", "+Inf"))...)
+		e.sample("tinycache_rpc_duration_seconds_sum", m.Total.Seconds(), labels...)
+		e.sample("tinycache_rpc_duration_seconds_count", float64(m.Count), labels...)
+	}
+}
+
+// exposition 按 Prometheus 文本格式写出指标
+type exposition struct {
+	w *bufio.Writer
+}
+
+func (e *exposition) family(name, help, typ string) {
+	fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (e *exposition) sample(name string, value float64, labels ...string) {
+	fmt.Fprintf(e.w, "%s{%s} %s\n", name, strings.Join(labels, ","), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// histogram 写出直方图的 _bucket、_sum 和 _count，桶的计数是累计的
+func (e *exposition) histogram(name string, h *histogram, labels ...string) {
+	var count int64
+	for i, bound := range latencyBuckets {
+		count += h.counts[i].Get()
+		e.sample(name+"_bucket", float64(count), append(labels, label("le", seconds(bound)))...)
+	}
+	count += h.counts[len(latencyBuckets)].Get()
+	e.sample(name+"_bucket", float64(count), append(labels, label("le", "+Inf"))...)
+	e.sample(name+"_sum", time.Duration(h.sum.Get()).Seconds(), labels...)
+	e.sample(name+"_count", float64(count), labels...)
+}
+
+// labelEscaper 转义标签值中的反斜杠、双引号和换行
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func label(name, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
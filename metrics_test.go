@@ -0,0 +1,91 @@
+package tinycache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	pb "tinycache/tinycachepb"
+)
+
+// scrape 请求 /metrics 并校验每一行都符合 Prometheus 文本格式
+func scrape(t *testing.T, h http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	body, _ := io.ReadAll(rec.Body)
+	sample := regexp.MustCompile(`^[a-z_]+\{([a-z_]+="([^"\\]|\\.)*",?)*\} \S+$`)
+	for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+		if !strings.HasPrefix(line, "# HELP ") && !strings.HasPrefix(line, "# TYPE ") && !sample.MatchString(line) {
+			t.Fatalf("malformed metrics line: %q", line)
+		}
+	}
+	return string(body)
+}
+
+func expectMetric(t *testing.T, body, line string) {
+	t.Helper()
+	if !strings.Contains(body, line+"\n") {
+		t.Fatalf("metrics should contain %q, got:\n%s", line, body)
+	}
+}
+
+func TestHTTPPoolMetrics(t *testing.T) {
+	loads := 0
+	g := newFailoverGroup("metrics", DefaultFailurePolicy, &stubPicker{owner: &stubPeer{err: fmt.Errorf("Tom not exist")}}, &loads)
+	g.Get("Tom")
+	g.peers = nil
+	g.Get("Jack")
+	g.Get("Jack")
+
+	owner := httptest.NewServer(NewHTTPPool("http://127.0.0.1"))
+	defer owner.Close()
+	pool := NewHTTPPool("http://node\"1")
+	pool.Set("http://node\"1", owner.URL)
+	pool.httpGetter[owner.URL].Get(&pb.Request{Group: "metrics-unknown", Key: "Sam"}, &pb.Response{}) // 失败的请求同样记录耗时
+
+	ts := httptest.NewServer(pool)
+	defer ts.Close()
+	res, err := http.Get(ts.URL + metricsPath)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics: %v %v", res, err)
+	}
+	res.Body.Close()
+
+	body := scrape(t, pool)
+	labels := `group="metrics",node="http://node\"1"`
+	expectMetric(t, body, `tinycache_gets_total{`+labels+`} 3`)
+	expectMetric(t, body, `tinycache_misses_total{`+labels+`} 2`)
+	expectMetric(t, body, `tinycache_hits_total{`+labels+`,cache="main"} 1`)
+	expectMetric(t, body, `tinycache_peer_errors_total{`+labels+`} 1`)
+	expectMetric(t, body, `tinycache_local_loads_total{`+labels+`} 1`)
+	expectMetric(t, body, `tinycache_cache_items{`+labels+`,cache="main"} 1`)
+	expectMetric(t, body, `tinycache_load_duration_seconds_count{`+labels+`,source="local"} 1`)
+	expectMetric(t, body, `tinycache_load_duration_seconds_bucket{`+labels+`,source="peer",le="+Inf"} 1`)
+	expectMetric(t, body, `tinycache_ring_peers{node="http://node\"1"} 2`)
+	expectMetric(t, body, `tinycache_peer_request_duration_seconds_count{node="http://node\"1",peer="`+owner.URL+`"} 1`)
+	if strings.Count(body, "# TYPE tinycache_gets_total counter\n") != 1 {
+		t.Fatalf("each metric family should be declared once")
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	NewGroup("metrics-rpc", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	rpc := NewRPCMetrics()
+	addr, _ := startTransports(t, WithRPCMetrics(rpc))
+	if err := peerClient(addr).Get(&pb.Request{Group: "metrics-rpc", Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	svr, _ := NewServer(addr, WithRPCMetrics(rpc))
+	svr.Set(addr, "127.0.0.1:1")
+
+	body := scrape(t, svr.MetricsHandler())
+	expectMetric(t, body, `tinycache_ring_peers{node="`+addr+`"} 2`)
+	expectMetric(t, body, `tinycache_peer_request_duration_seconds_count{node="`+addr+`",peer="127.0.0.1:1"} 0`)
+	expectMetric(t, body, `tinycache_rpc_duration_seconds_count{node="`+addr+`",side="server",method="/tinycachepb.GroupCache/Get"} 1`)
+}
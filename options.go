@@ -0,0 +1,149 @@
+package tinycache
+
+import (
+	"google.golang.org/grpc"
+	"time"
+	"tinycache/hash"
+)
+
+// Option 用于配置 Server 和 HTTPPOOL 的可选参数，通过 NewServer/NewHTTPPool 的可变参数传入
+type Option func(*options)
+
+// options 保存节点的可选配置
+type options struct {
+	weight       int                   // 当前节点的权重，注册到etcd时写入元数据，供其他节点计算虚拟节点数量
+	newPlacement func() hash.Placement // 创建节点放置算法的实例，默认为一致性哈希环
+	timeout      time.Duration         // 访问远程节点的超时时间
+	breaker      *BreakerConfig        // 远程节点的熔断器配置，为 nil 表示不熔断
+	retry        RetryPolicy           // 传输错误的重试策略
+	hedge        *HedgePolicy          // 对冲请求配置，为 nil 表示不对冲
+	direct       bool                  // gRPC 客户端直接拨号节点地址，不经过etcd服务发现
+	unaryServer  []grpc.UnaryServerInterceptor
+	streamServer []grpc.StreamServerInterceptor
+	unaryClient  []grpc.UnaryClientInterceptor
+	streamClient []grpc.StreamClientInterceptor
+	tls          *tlsFiles   // 节点间通信的 TLS 证书，为 nil 表示不加密
+	auth         peerAuth    // 节点间的认证方式，为 nil 表示不认证
+	warmup       bool        // 启动后处于未就绪状态，直到调用 SetReady(true)
+	rpcMetrics   *RPCMetrics // gRPC 方法的统计，不为 nil 时由 /metrics 导出
+	log          *logger     // 日志，WithLogger 修改的是同一个实例，拦截器等先创建的组件也能使用
+	adminToken   string      // 管理接口的令牌，为空时管理接口拒绝所有请求
+}
+
+// defaultOptions 返回默认配置
+func defaultOptions() options {
+	return options{
+		weight:  1,
+		timeout: 10 * time.Second,
+		log:     newLogger(),
+		newPlacement: func() hash.Placement {
+			return hash.NewConsistentHash(defaultReplicas, nil)
+		},
+	}
+}
+
+// WithWeight 设置当前节点的权重，节点注册时会把权重写入etcd，权重越大，在哈希环上分到的key越多
+func WithWeight(weight int) Option {
+	return func(o *options) {
+		if weight > 0 {
+			o.weight = weight
+		}
+	}
+}
+
+// WithPlacement 设置节点放置算法，例如 hash.NewRendezvous、hash.NewJump、hash.NewMaglev，
+// newPlacement 会在每次重建节点映射时被调用，所以需要每次返回新的实例
+func WithPlacement(newPlacement func() hash.Placement) Option {
+	return func(o *options) {
+		if newPlacement != nil {
+			o.newPlacement = newPlacement
+		}
+	}
+}
+
+// WithPeerTimeout 设置访问远程节点的超时时间，默认 10s
+func WithPeerTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
+// WithCircuitBreaker 为每个远程节点启用熔断器：连续传输错误达到阈值后直接拒绝访问该节点，
+// 由失败处理策略立即回退，不必每次都等到超时
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(o *options) {
+		o.breaker = &cfg
+	}
+}
+
+// WithRetry 设置访问远程节点发生传输错误时的重试策略，重试间隔为带随机抖动的指数退避
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// WithHedging 启用对冲请求：拥有者超过延迟分位数仍未返回时，向下一个候选节点发出相同请求
+func WithHedging(policy HedgePolicy) Option {
+	return func(o *options) {
+		o.hedge = &policy
+	}
+}
+
+// WithDirectDial 让 Server 访问远程节点时直接拨号节点地址，不经过etcd服务发现，
+// 适用于节点地址固定的集群，配合 Server.Serve 使用时不依赖etcd
+func WithDirectDial() Option {
+	return func(o *options) {
+		o.direct = true
+	}
+}
+
+// WithWarmup 让节点启动后处于未就绪状态：gRPC 健康检查返回 NOT_SERVING，HTTPPOOL 的 /readyz 返回 503，
+// 直到调用 SetReady(true)（例如预热完成）
+func WithWarmup() Option {
+	return func(o *options) {
+		o.warmup = true
+	}
+}
+
+// GroupOption 用于配置 Group 的可选参数，通过 NewGroup 的可变参数传入
+type GroupOption func(*Group)
+
+// WithWriteThrough 配置同步写（write-through）：Set 先写数据源，成功后再写缓存。
+// 如果 setter 同时实现了 Deleter，Delete 也会同步删除数据源中的数据
+func WithWriteThrough(setter Setter) GroupOption {
+	return func(g *Group) {
+		g.setter = setter
+		if d, ok := setter.(Deleter); ok && g.deleter == nil {
+			g.deleter = d
+		}
+	}
+}
+
+// WithWriteBehind 配置异步回写（write-behind）：Set 先写缓存，写操作进入有界队列，由后台协程批量、带重试地写回数据源。
+// 如果 setter 同时实现了 Deleter，Delete 也会异步删除数据源中的数据。调用 FlushWrites 可以立即写回队列中的操作
+func WithWriteBehind(setter Setter, cfg WriteBehindConfig) GroupOption {
+	return func(g *Group) {
+		g.setter = setter
+		if d, ok := setter.(Deleter); ok && g.deleter == nil {
+			g.deleter = d
+		}
+		g.writeBack = newWriteBehind(setter, cfg)
+	}
+}
+
+// WithDeleter 配置从数据源删除数据的方式，按 WithWriteThrough/WithWriteBehind 的模式同步或异步执行
+func WithDeleter(deleter Deleter) GroupOption {
+	return func(g *Group) {
+		g.deleter = deleter
+	}
+}
+
+// WithFailurePolicy 设置访问拥有者失败时的处理方式，默认为 DefaultFailurePolicy
+func WithFailurePolicy(policy FailurePolicy) GroupOption {
+	return func(g *Group) {
+		g.policy = policy
+	}
+}
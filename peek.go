@@ -0,0 +1,25 @@
+package tinycache
+
+// Peek 在本节点的 hotCache 和 mainCache 中查找 key，没有时返回 false。
+// 与 Get 不同，Peek 不会访问远程节点或数据源，不改变记录的访问顺序或频率，也不计入统计和热点 key
+func (g *Group) Peek(key string) (ByteView, bool) {
+	value, _, ok := g.PeekWithSource(key)
+	return value, ok
+}
+
+// PeekWithSource 同 Peek，同时返回数据在 hotCache 还是 mainCache 中
+func (g *Group) PeekWithSource(key string) (ByteView, Source, bool) {
+	if v, ok := g.hotCache.peek(key); ok {
+		return v, SourceHotCache, true
+	}
+	if v, ok := g.mainCache.peek(key); ok {
+		return v, SourceMainCache, true
+	}
+	return ByteView{}, 0, false
+}
+
+// Contains 判断本节点的 hotCache 或 mainCache 中是否有未过期的 key，没有副作用
+func (g *Group) Contains(key string) bool {
+	_, ok := g.Peek(key)
+	return ok
+}
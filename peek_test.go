@@ -0,0 +1,28 @@
+package tinycache
+
+import (
+	"testing"
+)
+
+func TestGroupPeek(t *testing.T) {
+	loads := 0
+	g := NewGroup("peek", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(db[key]), nil
+	}))
+	if _, ok := g.Peek("Tom"); ok || g.Contains("Tom") || loads != 0 {
+		t.Fatalf("Peek should not load missing keys, loads=%d", loads)
+	}
+	g.Get("Tom")
+	v, source, ok := g.PeekWithSource("Tom")
+	if !ok || v.String() != "630" || source != SourceMainCache || !g.Contains("Tom") {
+		t.Fatalf("PeekWithSource: %q %v %v", v.String(), source, ok)
+	}
+	g.populateHotCache("Jack", ByteView{b: []byte("589")})
+	if _, source, ok := g.PeekWithSource("Jack"); !ok || source != SourceHotCache {
+		t.Fatalf("Jack should be found in hotCache")
+	}
+	if s := g.Stats(); s.Gets != 1 || loads != 1 || len(g.HotKeys(0).Gets) != 1 {
+		t.Fatalf("Peek should not be counted: %+v", s)
+	}
+}
@@ -1,6 +1,10 @@
 package tinycache
 
-import pb "tinycache/tinycachepb"
+import (
+	"context"
+	"io"
+	pb "tinycache/tinycachepb"
+)
 
 // PeerPicker 可以通过PeerPicker接口找到对应key的peer(对等节点)
 type PeerPicker interface {
@@ -11,3 +15,61 @@ type PeerPicker interface {
 type PeerGetter interface {
 	Get(in *pb.Request, out *pb.Response) error // 用于对应的group查找缓存值
 }
+
+// PeerContextGetter 是 PeerGetter 的可选扩展，ctx 中的追踪上下文会随请求传给远程节点
+type PeerContextGetter interface {
+	GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// getFromPeerContext 优先使用 PeerContextGetter 访问远程节点
+func getFromPeerContext(ctx context.Context, peer PeerGetter, in *pb.Request, out *pb.Response) error {
+	if cg, ok := peer.(PeerContextGetter); ok {
+		return cg.GetContext(ctx, in, out)
+	}
+	return peer.Get(in, out)
+}
+
+// PeerInvalidator 用于通知远程节点删除某个key的缓存副本
+type PeerInvalidator interface {
+	Invalidate(in *pb.InvalidateRequest) error
+}
+
+// PeerLister 用于获取除自身外的所有远程节点，例如广播失效消息
+type PeerLister interface {
+	ListPeers() []PeerGetter
+}
+
+// PeerLeaser 用于向key的拥有者（拥有者不可用时为下一个候选节点）申请和归还加载数据源的租约，避免多个节点同时访问数据源
+type PeerLeaser interface {
+	AcquireLease(in *pb.LeaseRequest) (*pb.LeaseResponse, error)
+	ReleaseLease(in *pb.ReleaseRequest) error
+}
+
+// PeerSetter 用于把需要在拥有者上原子执行的写入（例如 CompareAndSet）转发给拥有者
+type PeerSetter interface {
+	Set(in *pb.SetRequest) (*pb.SetResponse, error)
+}
+
+// PeerStreamer 由支持分段传输缓存值的 PeerGetter 实现，缓存值直接写入 w，
+// 返回的 Response 只携带版本号等元数据，Value 为空
+type PeerStreamer interface {
+	GetStream(in *pb.Request, w io.Writer) (*pb.Response, error)
+}
+
+// PeerContextStreamer 是 PeerStreamer 的可选扩展，ctx 的截止时间、取消和追踪上下文会随请求传给远程节点
+type PeerContextStreamer interface {
+	GetStreamContext(ctx context.Context, in *pb.Request, w io.Writer) (*pb.Response, error)
+}
+
+// getStreamFromPeerContext 优先使用 PeerContextStreamer 分段获取缓存值，peer 不支持分段传输时返回 false
+func getStreamFromPeerContext(ctx context.Context, peer PeerGetter, in *pb.Request, w io.Writer) (*pb.Response, bool, error) {
+	if cs, ok := peer.(PeerContextStreamer); ok {
+		res, err := cs.GetStreamContext(ctx, in, w)
+		return res, true, err
+	}
+	if s, ok := peer.(PeerStreamer); ok {
+		res, err := s.GetStream(in, w)
+		return res, true, err
+	}
+	return nil, false, nil
+}
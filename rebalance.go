@@ -0,0 +1,150 @@
+package tinycache
+
+import (
+	"context"
+	"sync"
+	"tinycache/hash"
+	pb "tinycache/tinycachepb"
+)
+
+// handoff 按 hash.Delta 找出本节点 mainCache 中拥有者从 old 变为 cur 中其他节点的数据，按新的拥有者分组，
+// 通过 Migrate 流式推送过去，推送成功后，推送之后没有被修改过的数据从本地删除。拥有者没有变化的数据不会推送。
+// 这样新加入的节点不会从冷缓存开始，正常下线的节点也不会丢失缓存。
+// ctx 结束时停止迁移，剩下的数据留在本地
+func (s *Server) handoff(ctx context.Context, old, cur hash.Placement) {
+	if cur == nil {
+		return
+	}
+	for _, g := range allGroups() {
+		for owner, batch := range s.handoffEntries(g, old, cur) {
+			if ctx.Err() != nil {
+				return
+			}
+			s.mu.Lock()
+			client, ok := s.clients[owner]
+			s.mu.Unlock()
+			if !ok {
+				continue
+			}
+			accepted, err := client.MigrateContext(ctx, batch)
+			if err != nil {
+				s.opts.log.get().Warn("migrate entries failed", "node", s.self, "group", g.name, "peer", owner, "entries", len(batch), "err", err)
+				continue
+			}
+			for _, entry := range batch {
+				g.removeMigrated(entry.Key, entry.Version)
+			}
+			s.opts.log.get().Info("migrated entries", "node", s.self, "group", g.name, "peer", owner, "entries", len(batch), "accepted", accepted)
+		}
+	}
+}
+
+// handoffEntries 返回 g 的 mainCache 中拥有者从 old 变为 cur 中其他节点的数据，按新的拥有者分组
+func (s *Server) handoffEntries(g *Group, old, cur hash.Placement) map[string][]*pb.Entry {
+	var keys []string
+	values := make(map[string]ByteView)
+	g.mainCache.forEach(func(key string, value ByteView) bool {
+		keys = append(keys, key)
+		values[key] = value
+		return true
+	})
+	entries := make(map[string][]*pb.Entry) // 新的拥有者 -> 需要推送的数据
+	for _, move := range hash.Delta(old, cur, keys) {
+		if move.To == "" || move.To == s.self {
+			continue
+		}
+		entries[move.To] = append(entries[move.To], &pb.Entry{
+			Group:   g.name,
+			Key:     move.Key,
+			Value:   values[move.Key].ByteSlice(),
+			Version: values[move.Key].version,
+		})
+	}
+	return entries
+}
+
+// removeMigrated 从 mainCache 删除已经迁移出去的 key。迁移期间 key 被写入了新版本时保留本地的新值，返回是否删除
+func (g *Group) removeMigrated(key string, version uint64) bool {
+	defer g.lockInval()()
+	if v, ok := g.mainCache.peek(key); !ok || v.version != version {
+		return false
+	}
+	g.mainCache.remove(key)
+	return true
+}
+
+// handoffQueue 在一个后台协程中串行执行节点变化后的迁移，只保留最新的节点映射：
+// 迁移进行中节点再次变化时，当前的迁移被取消，之后按最新的映射重新迁移
+type handoffQueue struct {
+	mu      sync.Mutex
+	pending hash.Placement     // 等待迁移的最新节点映射
+	base    hash.Placement     // 最早一次还没有完成迁移的变化之前的映射，按它和 pending 计算拥有者的变化
+	running bool               // 后台协程是否在运行
+	cancel  context.CancelFunc // 取消正在进行的迁移
+	wg      sync.WaitGroup
+}
+
+// scheduleHandoff 提交节点映射从 old 变为 cur 的变化，由后台协程迁移
+func (s *Server) scheduleHandoff(old, cur hash.Placement) {
+	q := &s.handoffs
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		q.base = old // 已经有等待的迁移时保留更早的映射
+	}
+	q.pending = cur
+	if q.running {
+		if q.cancel != nil {
+			q.cancel() // 正在进行的迁移使用的映射已经过时
+		}
+		return
+	}
+	q.running = true
+	q.wg.Add(1)
+	go s.runHandoffs()
+}
+
+// runHandoffs 依次执行等待中的迁移，没有等待的迁移时退出
+func (s *Server) runHandoffs() {
+	q := &s.handoffs
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		old, cur := q.base, q.pending
+		if cur == nil {
+			q.running, q.cancel = false, nil
+			q.mu.Unlock()
+			return
+		}
+		q.base, q.pending = nil, nil
+		ctx, cancel := context.WithCancel(context.Background())
+		q.cancel = cancel
+		q.mu.Unlock()
+
+		s.handoff(ctx, old, cur)
+		q.mu.Lock()
+		if ctx.Err() != nil {
+			q.base = old // 被取消的迁移没有完成，剩下的数据仍按它之前的映射计算拥有者的变化
+		}
+		q.mu.Unlock()
+		cancel()
+	}
+}
+
+// stopHandoffs 丢弃等待中的迁移，取消正在进行的迁移并等待后台协程退出。
+// 返回还没有完成迁移的变化之前的映射，没有时返回 nil
+func (s *Server) stopHandoffs() hash.Placement {
+	q := &s.handoffs
+	q.mu.Lock()
+	q.pending = nil
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	base := q.base
+	q.base = nil
+	return base
+}
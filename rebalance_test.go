@@ -0,0 +1,133 @@
+package tinycache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"tinycache/hash"
+)
+
+// 迁移期间被写入新版本的key不能从本地删除
+func TestRemoveMigrated(t *testing.T) {
+	g := NewGroup("rebalance", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	sent, _ := g.Set("Tom", []byte("630"))
+	current, _ := g.Set("Tom", []byte("631"))
+	if g.removeMigrated("Tom", sent) {
+		t.Fatalf("key written after it was sent should be kept")
+	}
+	if v, ok := g.mainCache.get("Tom"); !ok || v.String() != "631" {
+		t.Fatalf("newer value should stay in mainCache, got %v %v", v, ok)
+	}
+	if !g.removeMigrated("Tom", current) {
+		t.Fatalf("unchanged key should be removed after migration")
+	}
+}
+
+func TestHandoffQueue(t *testing.T) {
+	s, _ := NewServer("127.0.0.1:0")
+	for i := 0; i < 10; i++ {
+		p := hash.NewConsistentHash(defaultReplicas, nil)
+		p.Add("127.0.0.1:0")
+		s.scheduleHandoff(nil, p)
+	}
+	s.stopHandoffs()
+	s.handoffs.mu.Lock()
+	defer s.handoffs.mu.Unlock()
+	if s.handoffs.running || s.handoffs.pending != nil {
+		t.Fatalf("stopped queue should be idle, running=%v pending=%v", s.handoffs.running, s.handoffs.pending)
+	}
+}
+
+// weightUpdate 是注册中心中一个节点声明的权重
+type weightUpdate struct {
+	addr   string
+	weight int
+}
+
+// 注册中心中的权重变化后自动重建节点映射，不需要手动同步；之后加入集群的节点使用它声明的权重
+func TestWatchWeights(t *testing.T) {
+	self, peer, late := "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"
+	s, _ := NewServer(self, WithDirectDial())
+	defer s.stopHandoffs()
+	s.Set(self, peer)
+	owned := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if s.peers.Get(fmt.Sprintf("key-%d", i)) == peer {
+				n++
+			}
+		}
+		return n
+	}
+	before := owned()
+
+	updates := make(chan weightUpdate)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.watchWeights(ctx, func(ctx context.Context, fn func(addr string, weight int)) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case u := <-updates:
+				fn(u.addr, u.weight)
+			}
+		}
+	})
+	updates <- weightUpdate{peer, 5}
+	updates <- weightUpdate{late, 3}
+	updates <- weightUpdate{self, 1} // 收到这条时，之前的更新都已经处理完
+	if after := owned(); after <= before {
+		t.Fatalf("a heavier registered weight should move keys to %s, owned %d before and %d after", peer, before, after)
+	}
+
+	s.Set(self, peer, late)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nodes[peer] != 5 || s.nodes[late] != 3 || s.nodes[self] != 1 {
+		t.Fatalf("Set should use the registered weights, got %v", s.nodes)
+	}
+}
+
+// 节点变化后只迁移拥有者发生变化的数据，拥有者没有变化的本地副本留在本地
+func TestHandoffEntriesDelta(t *testing.T) {
+	self, other, joined := "127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"
+	g := NewGroup("rebalance-delta", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	var keys []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		g.Set(key, []byte("v"))
+		keys = append(keys, key)
+	}
+	old := hash.NewConsistentHash(defaultReplicas, nil)
+	old.Add(self, other)
+	cur := hash.NewConsistentHash(defaultReplicas, nil)
+	cur.Add(self, other, joined)
+
+	s, _ := NewServer(self)
+	entries := s.handoffEntries(g, old, cur)
+	if len(entries[other]) != 0 || len(entries[self]) != 0 {
+		t.Fatalf("entries whose owner did not change should stay local, got %v", entries)
+	}
+	moved := map[string]bool{}
+	for _, entry := range entries[joined] {
+		moved[entry.Key] = true
+	}
+	for _, key := range keys {
+		if want := old.Get(key) != joined && cur.Get(key) == joined; moved[key] != want {
+			t.Fatalf("%s: moved=%v, owner %s -> %s", key, moved[key], old.Get(key), cur.Get(key))
+		}
+	}
+	if len(moved) == 0 {
+		t.Fatalf("some keys should move to the joined node")
+	}
+	if entries = s.handoffEntries(g, cur, cur); len(entries) != 0 {
+		t.Fatalf("an unchanged placement should not move anything, got %v", entries)
+	}
+}
@@ -1,22 +1,95 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
 	"go.etcd.io/etcd/client/v3/naming/resolver"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-// EtcdDial 向grpc请求一个服务，通过提供一个etcd client和service name即可获得Connection
-func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+// EtcdDial 向grpc请求一个服务，通过提供一个etcd client和service name即可获得Connection，
+// opts 会追加在默认选项之后，例如用于添加拦截器
+func EtcdDial(c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	etcdResolver, err := resolver.NewBuilder(c) //使用etcd客户端构建了一个服务发现的构建器。
 	if err != nil {                             //检查是否在创建etcd服务发现构建器时发生了错误
 		return nil, err
 	}
 	return grpc.Dial(
-		"etcd:///"+service,                                       //指定了服务的地址
-		grpc.WithResolvers(etcdResolver),                         //用于服务发现的解析器
-		grpc.WithTransportCredentials(insecure.NewCredentials()), //用于设置gRPC连接的传输层安全性，这里使用了不安全的连接（insecure）
-		grpc.WithBlock(),                                         //用于在连接建立之前阻塞，确保连接建立成功后再继续执行后续的代码。
+		"etcd:///"+service, //指定了服务的地址
+		append([]grpc.DialOption{
+			grpc.WithResolvers(etcdResolver),                         //用于服务发现的解析器
+			grpc.WithTransportCredentials(insecure.NewCredentials()), //用于设置gRPC连接的传输层安全性，这里使用了不安全的连接（insecure）
+			grpc.WithBlock(), //用于在连接建立之前阻塞，确保连接建立成功后再继续执行后续的代码。
+		}, opts...)...,
 	)
 } // 最后返回一个指向已建立连接的grpc.ClientConn类型的指针，或者在发生错误时返回一个错误
+
+// EtcdMetadata 读取某个服务注册时携带的元数据，如果服务没有携带元数据，返回权重为1的默认值
+func EtcdMetadata(c *clientv3.Client, service string) (Metadata, error) {
+	em, err := endpoints.NewManager(c, service)
+	if err != nil {
+		return Metadata{Weight: 1}, err
+	}
+	eps, err := em.List(c.Ctx())
+	if err != nil {
+		return Metadata{Weight: 1}, err
+	}
+	for _, ep := range eps {
+		if ep.Metadata != nil {
+			return decodeMetadata(ep.Metadata)
+		}
+	}
+	return Metadata{Weight: 1}, nil
+}
+
+// WatchMetadata 监听 prefix 下所有服务注册时携带的元数据：先对已经注册的服务调用一次 fn，
+// 之后每当有服务注册或更新元数据时再调用 fn，直到 ctx 结束或监听中断。addr 为服务的地址
+func WatchMetadata(ctx context.Context, c *clientv3.Client, prefix string, fn func(addr string, md Metadata)) error {
+	em, err := endpoints.NewManager(c, prefix)
+	if err != nil {
+		return err
+	}
+	ch, err := em.NewWatchChannel(ctx)
+	if err != nil {
+		return err
+	}
+	for updates := range ch {
+		for _, up := range updates {
+			if up.Op != endpoints.Add {
+				continue
+			}
+			md, err := decodeMetadata(up.Endpoint.Metadata)
+			if err != nil {
+				continue
+			}
+			fn(up.Endpoint.Addr, md)
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("watch %s closed", prefix)
+}
+
+// decodeMetadata 解析 endpoints 中保存的元数据，权重小于1时使用1
+func decodeMetadata(raw interface{}) (Metadata, error) {
+	md := Metadata{Weight: 1}
+	if raw != nil {
+		// endpoints 以json形式保存Metadata，读出来是map[string]interface{}，这里重新编解码一次
+		b, err := json.Marshal(raw)
+		if err != nil {
+			return md, err
+		}
+		if err = json.Unmarshal(b, &md); err != nil {
+			return md, err
+		}
+	}
+	if md.Weight < 1 {
+		md.Weight = 1
+	}
+	return md, nil
+}
@@ -20,9 +20,14 @@ var (
 	}
 )
 
+// Metadata 是节点注册服务时随地址一起写入etcd的附加信息，其他节点可以通过 EtcdMetadata 读取
+type Metadata struct {
+	Weight int `json:"weight"` // 节点权重，决定节点在一致性哈希环上的虚拟节点数量
+}
+
 // etcdAdd 在租赁模式添加一对kv至etcd
-// 四个参数分别是etcd客户端，etcd租约ID，服务名称，服务地址
-func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr string) error {
+// 五个参数分别是etcd客户端，etcd租约ID，服务名称，服务地址，节点元数据
+func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr string, md Metadata) error {
 	em, err := endpoints.NewManager(c, service) //创建一个用于管理 etcd 中的服务端点（endpoints）
 	if err != nil {
 		return err
@@ -30,12 +35,26 @@ func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr stri
 	//该方法用于将指定的服务地址（addr）添加到 etcd 中的服务端点列表中。
 	//clientv3.WithLease(lid) 选项表示使用指定的租约 ID（lid）来设置键值的生命周期。
 	//如果添加服务地址成功，函数会返回 nil 表示没有错误；如果发生错误，函数会返回相应的错误信息
-	return em.AddEndpoint(c.Ctx(), service+"/"+addr, endpoints.Endpoint{Addr: addr}, clientv3.WithLease(lid))
+	return em.AddEndpoint(c.Ctx(), service+"/"+addr, endpoints.Endpoint{Addr: addr, Metadata: md}, clientv3.WithLease(lid))
 }
 
 // Register 注册一个服务至etcd,并且在服务的生命周期内保持心跳检测，确保服务的持续在线。
 // 注意 Register将不会return 如果没有error的话
 func Register(service string, addr string, stop chan error) error {
+	return RegisterWithMetadata(service, addr, Metadata{Weight: 1}, stop)
+}
+
+// RegisterWithMetadata 同 Register，注册时额外携带节点元数据（例如权重）
+func RegisterWithMetadata(service string, addr string, md Metadata, stop chan error) error {
+	return RegisterNotify(service, addr, md, stop, nil)
+}
+
+// RegisterNotify 同 RegisterWithMetadata，注册成功后调用 notify(true)，注册失效（停止、心跳中断）后调用 notify(false)，
+// 用于根据节点是否在注册中心中报告健康状态。notify 可以为 nil
+func RegisterNotify(service string, addr string, md Metadata, stop chan error, notify func(registered bool)) error {
+	if notify == nil {
+		notify = func(bool) {}
+	}
 	// 创建一个etcd client
 	cli, err := clientv3.New(defaultEtcdConfig)
 	if err != nil {
@@ -49,7 +68,7 @@ func Register(service string, addr string, stop chan error) error {
 	}
 	leaseId := resp.ID //获取了该租约的 ID
 	// 注册服务
-	err = etcdAdd(cli, leaseId, service, addr)
+	err = etcdAdd(cli, leaseId, service, addr, md)
 	if err != nil {
 		return fmt.Errorf("add etcd record failed: %v", err)
 	}
@@ -60,6 +79,8 @@ func Register(service string, addr string, stop chan error) error {
 	}
 
 	log.Printf("[%s] register service ok\n", addr)
+	notify(true)
+	defer notify(false)
 	for {
 		select {
 		case err := <-stop:
@@ -0,0 +1,93 @@
+package tinycache
+
+import (
+	"sort"
+	"strings"
+)
+
+// CacheStats 是 mainCache 或 hotCache 的统计信息
+type CacheStats struct {
+	Items       int64 `json:"items"`       // 记录数，包含已过期但还没有被删除的记录
+	Bytes       int64 `json:"bytes"`       // 记录的 key 和 value 占用的字节数，与 Items 统计的记录相同
+	Evictions   int64 `json:"evictions"`   // 因容量不足被淘汰的记录数
+	Expirations int64 `json:"expirations"` // 因过期被删除的记录数
+}
+
+// GroupStats 是 Group 的统计信息，计数从 Group 创建开始累计
+type GroupStats struct {
+	Name            string     `json:"name"`
+	Gets            int64      `json:"gets"`              // Get 的调用次数
+	HotHits         int64      `json:"hot_hits"`          // 命中 hotCache 的次数
+	MainHits        int64      `json:"main_hits"`         // 命中 mainCache 的次数
+	PeerLoads       int64      `json:"peer_loads"`        // 从远程节点加载成功的次数
+	PeerErrors      int64      `json:"peer_errors"`       // 从远程节点加载失败的次数
+	LocalLoads      int64      `json:"local_loads"`       // 从数据源加载成功的次数
+	LocalLoadErrors int64      `json:"local_load_errors"` // 从数据源加载失败的次数
+	LoadsDeduped    int64      `json:"loads_deduped"`     // 被 singleflight 合并、等待其他请求加载结果的次数
+	MainCache       CacheStats `json:"main_cache"`
+	HotCache        CacheStats `json:"hot_cache"`
+}
+
+// groupCounters 是 Group 的原子计数器
+type groupCounters struct {
+	gets            AtomicInt
+	hotHits         AtomicInt
+	mainHits        AtomicInt
+	peerLoads       AtomicInt
+	peerErrors      AtomicInt
+	localLoads      AtomicInt
+	localLoadErrors AtomicInt
+	loadsDeduped    AtomicInt
+
+	localLoadLatency histogram // 从数据源加载的耗时
+	peerLoadLatency  histogram // 从远程节点加载的耗时
+}
+
+// Stats 返回 Group 的统计信息快照
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Name:            g.name,
+		Gets:            g.stats.gets.Get(),
+		HotHits:         g.stats.hotHits.Get(),
+		MainHits:        g.stats.mainHits.Get(),
+		PeerLoads:       g.stats.peerLoads.Get(),
+		PeerErrors:      g.stats.peerErrors.Get(),
+		LocalLoads:      g.stats.localLoads.Get(),
+		LocalLoadErrors: g.stats.localLoadErrors.Get(),
+		LoadsDeduped:    g.stats.loadsDeduped.Get(),
+		MainCache:       cacheStats(g.mainCache),
+		HotCache:        cacheStats(g.hotCache),
+	}
+}
+
+// HitRate 返回命中 hotCache 或 mainCache 的比例，没有请求时返回 0
+func (s GroupStats) HitRate() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.HotHits+s.MainHits) / float64(s.Gets)
+}
+
+// cacheStats 从缓存维护的计数中读取记录数和字节数，不遍历缓存
+func cacheStats(c BaseCache) CacheStats {
+	items, bytes := c.usage()
+	return CacheStats{
+		Items:       int64(items),
+		Bytes:       bytes,
+		Evictions:   c.counters().evictions.Get(),
+		Expirations: c.counters().expirations.Get(),
+	}
+}
+
+// Keys 按字典序返回 mainCache 中以 prefix 开头的 key，只包含本节点拥有的数据，不包含 hotCache
+func (g *Group) Keys(prefix string) []string {
+	var keys []string
+	g.mainCache.forEach(func(key string, value ByteView) bool {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	sort.Strings(keys)
+	return keys
+}
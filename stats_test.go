@@ -0,0 +1,76 @@
+package tinycache
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupStats(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("stats", 64, "lru", GetterFunc(func(key string) ([]byte, error) {
+		switch key {
+		case "slow":
+			close(started)
+			<-release
+		case "missing":
+			return nil, fmt.Errorf("%s not exist", key)
+		}
+		return []byte(strings.Repeat("v", 10)), nil
+	}))
+
+	g.Get("k1")
+	g.Get("k1")
+	g.Get("missing")
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Get("slow")
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+	time.Sleep(20 * time.Millisecond) // 等待第二个请求进入 singleflight
+	close(release)
+	wg.Wait()
+
+	s := g.Stats()
+	if s.Gets != 5 || s.MainHits != 1 || s.HotHits != 0 || s.LocalLoads != 2 || s.LocalLoadErrors != 1 || s.LoadsDeduped != 1 {
+		t.Fatalf("unexpected counters: %+v", s)
+	}
+	if s.MainCache.Items != 2 || s.MainCache.Bytes != int64(len("k1")+len("slow")+20) {
+		t.Fatalf("unexpected main cache stats: %+v", s.MainCache)
+	}
+	if rate := s.HitRate(); rate != 0.2 {
+		t.Fatalf("hit rate: got %v, want 0.2", rate)
+	}
+
+	// 容量为 64 字节，第 5 个 12 字节的记录写入时淘汰最久未使用的记录
+	for i := 2; i <= 5; i++ {
+		g.Get(fmt.Sprintf("k%d", i))
+	}
+	g.Set("short", []byte("v"), WithTTL(time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	if _, err := g.Get("short"); err != nil {
+		t.Fatal(err)
+	}
+	if s = g.Stats(); s.MainCache.Evictions == 0 || s.MainCache.Expirations != 1 {
+		t.Fatalf("unexpected eviction counters: %+v", s.MainCache)
+	}
+}
+
+func TestGroupStatsPeer(t *testing.T) {
+	loads := 0
+	g := newFailoverGroup("stats-peer", DefaultFailurePolicy, &stubPicker{owner: &stubPeer{value: "630"}}, &loads)
+	g.Get("Tom")
+	g.peers = &stubPicker{owner: &stubPeer{err: &transportError{err: fmt.Errorf("connection refused")}}}
+	g.Get("Jack")
+	if s := g.Stats(); s.PeerLoads != 1 || s.PeerErrors != 1 || s.LocalLoads != 1 {
+		t.Fatalf("unexpected peer counters: %+v", s)
+	}
+}
@@ -2,7 +2,6 @@ package lfu
 
 import (
 	"container/heap"
-	"log"
 	"time"
 )
 
@@ -12,7 +11,9 @@ type LFUCache struct {
 	nBytes     int64                         // 已占用的容量
 	heap       *entryHeap                    // 使用一个 heap 来管理缓存项，heap 中的元素按照频率排序(heap实现了一个最小堆，即堆顶元素是最小值)
 	cache      map[string]*entry             // 键是字符串，值是堆中对应节点的指针
-	OnEvicted  func(key string, value Value) // 是某条记录被移除时的回调函数，可以为 nil
+	OnEvicted  func(key string, value Value) // 是某条记录因容量不足被淘汰时的回调函数，可以为 nil
+	OnExpired  func(key string, value Value) // 是某条记录因过期被移除时的回调函数，可以为 nil
+	OnRemoved  func(key string, value Value) // 是某条记录被 Remove 主动删除时的回调函数，可以为 nil
 	defaultTTL time.Duration                 // 记录在缓存中的默认过期时间
 }
 
@@ -77,11 +78,11 @@ func New(maxBytes int64, onEvicted func(string, Value), defaultTTL time.Duration
 	}
 }
 
+// Get 函数返回 key 对应的值并增加访问频率，记录已过期时删除该记录并返回 false
 func (c *LFUCache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
 		if ele.expire.Before(time.Now()) {
-			c.removeElement(ele)
-			log.Printf("The LFUcache key—%s has expired", key)
+			c.removeElement(ele, c.OnExpired)
 			return nil, false
 		}
 		ele.freq++
@@ -93,13 +94,27 @@ func (c *LFUCache) Get(key string) (value Value, ok bool) {
 	return
 }
 
-// RemoveOldest 函数删除频率最低的缓存项。
+// Peek 函数返回 key 对应的值，不会增加访问频率，也不会删除已过期的缓存项，缓存项已过期时返回 false。
+func (c *LFUCache) Peek(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		if ele.expire.Before(time.Now()) {
+			return nil, false
+		}
+		return ele.value, true
+	}
+	return
+}
+
+// RemoveOldest 函数删除频率最低的缓存项，该缓存项已过期时调用 OnExpired，否则调用 OnEvicted。
 func (c *LFUCache) RemoveOldest() {
-	entry := heap.Pop(c.heap).(*entry)
-	delete(c.cache, entry.key)
-	c.nBytes -= int64(len(entry.key)) + int64(entry.value.Len())
-	if c.OnEvicted != nil {
-		c.OnEvicted(entry.key, entry.value)
+	if c.heap.Len() == 0 {
+		return
+	}
+	entry := (*c.heap)[0]
+	if entry.expire.Before(time.Now()) {
+		c.removeElement(entry, c.OnExpired)
+	} else {
+		c.removeElement(entry, c.OnEvicted)
 	}
 }
 
@@ -107,6 +122,7 @@ func (c *LFUCache) RemoveOldest() {
 func (c *LFUCache) Add(key string, value Value, ttl time.Duration) {
 	if ele, ok := c.cache[key]; ok {
 		ele.freq++
+		c.nBytes += int64(value.Len()) - int64(ele.value.Len())
 		ele.value = value
 		ele.expire = time.Now().Add(ttl)
 		heap.Fix(c.heap, ele.index)
@@ -127,17 +143,52 @@ func (c *LFUCache) Add(key string, value Value, ttl time.Duration) {
 	}
 }
 
+// Range 遍历缓存中未过期的记录，遍历顺序不确定，fn 返回 false 时停止遍历。遍历不会改变记录的访问频率。
+func (c *LFUCache) Range(fn func(key string, value Value) bool) {
+	now := time.Now()
+	for key, ele := range c.cache {
+		if ele.expire.Before(now) {
+			continue
+		}
+		if !fn(key, ele.value) {
+			return
+		}
+	}
+}
+
+// Remove 方法删除指定的key并调用 OnRemoved，key 不存在时返回 false
+func (c *LFUCache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, c.OnRemoved)
+		return true
+	}
+	return false
+}
+
+// SetMaxBytes 方法修改最大存储容量（0 表示不限制），超出新容量时连续移除频率最低的记录
+func (c *LFUCache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nBytes {
+		c.RemoveOldest()
+	}
+}
+
+// Bytes 方法返回当前缓存中所有记录的 key 和 value 占用的字节数。
+func (c *LFUCache) Bytes() int64 {
+	return c.nBytes
+}
+
 // Len 方法返回当前缓存中的记录数量。
 func (c *LFUCache) Len() int {
 	return len(c.cache)
 }
 
-// removeElement 函数删除传入的缓存项。
-func (c *LFUCache) removeElement(e *entry) {
+// removeElement 函数删除传入的缓存项，callback 不为 nil 时调用 callback。
+func (c *LFUCache) removeElement(e *entry, callback func(key string, value Value)) {
 	heap.Remove(c.heap, e.index)
 	delete(c.cache, e.key)
 	c.nBytes -= int64(len(e.key)) + int64(e.value.Len())
-	if c.OnEvicted != nil {
-		c.OnEvicted(e.key, e.value)
+	if callback != nil {
+		callback(e.key, e.value)
 	}
 }
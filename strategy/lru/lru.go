@@ -15,7 +15,9 @@ type LRUCache struct {
 	nBytes     int64                         // 当前已经使用的内存
 	ll         *list.List                    // 双向链表常用于维护缓存中各个数据的访问顺序，以便在淘汰数据时能够方便地找到最近最少使用的数据
 	cache      map[string]*list.Element      // 键是字符串，值是双向链表中对应节点的指针
-	OnEvicted  func(key string, value Value) // 某条记录被移除时的回调函数，可以为 nil
+	OnEvicted  func(key string, value Value) // 某条记录因容量不足被淘汰时的回调函数，可以为 nil
+	OnExpired  func(key string, value Value) // 某条记录因过期被移除时的回调函数，可以为 nil
+	OnRemoved  func(key string, value Value) // 某条记录被 Remove 主动删除时的回调函数，可以为 nil
 	defaultTTL time.Duration                 // 记录在缓存中的默认过期时间
 }
 
@@ -37,11 +39,13 @@ func New(maxBytes int64, onEvicted func(string, Value), defaultTTL time.Duration
 	}
 }
 
+// Get 方法返回 key 对应的值并把节点移动到链表的最前面，记录已过期时删除该记录并返回 false
 func (c *LRUCache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
 		kv := ele.Value.(*entry)
 		if kv.expire.Before(time.Now()) {
-
+			c.removeElement(ele, c.OnExpired)
+			return nil, false
 		}
 		c.ll.MoveToFront(ele)
 		return kv.value, true
@@ -49,6 +53,18 @@ func (c *LRUCache) Get(key string) (value Value, ok bool) {
 	return
 }
 
+// Peek 方法返回 key 对应的值，不会移动节点，也不会删除已过期的记录，记录已过期时返回 false
+func (c *LRUCache) Peek(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expire.Before(time.Now()) {
+			return nil, false
+		}
+		return kv.value, true
+	}
+	return
+}
+
 // Add 方法用于向缓存中添加新的键值对。如果键已存在，则更新对应的值，并将节点移动到链表的最前面；
 // 如果键不存在，则在链表头部插入新的节点，并更新已占用的容量。
 // 如果添加新的键值对后超出了最大存储容量，则会连续移除最久未使用的记录，直到满足容量要求。
@@ -72,29 +88,70 @@ func (c *LRUCache) Add(key string, value Value, ttl time.Duration) {
 	}
 }
 
+// Range 按从新到旧的顺序遍历缓存中未过期的记录，fn 返回 false 时停止遍历。遍历不会改变记录的访问顺序。
+func (c *LRUCache) Range(fn func(key string, value Value) bool) {
+	now := time.Now()
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		kv := e.Value.(*entry)
+		if kv.expire.Before(now) {
+			continue
+		}
+		if !fn(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// Remove 方法删除指定的key并调用 OnRemoved，key 不存在时返回 false
+func (c *LRUCache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, c.OnRemoved)
+		return true
+	}
+	return false
+}
+
+// SetMaxBytes 方法修改最大存储容量（0 表示不限制），超出新容量时连续移除最久未使用的记录
+func (c *LRUCache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nBytes {
+		c.RemoveOldest()
+	}
+}
+
+// Bytes 方法返回当前缓存中所有记录的 key 和 value 占用的字节数。
+func (c *LRUCache) Bytes() int64 {
+	return c.nBytes
+}
+
 // Len 方法返回当前缓存中的记录数量。
 func (c *LRUCache) Len() int {
 	return c.ll.Len()
 }
 
-// RemoveOldest 方法用于移除最近最少访问的节点（队首节点）
+// RemoveOldest 方法用于移除最近最少访问的节点（队尾节点），该节点已过期时调用 OnExpired，否则调用 OnEvicted
 func (c *LRUCache) RemoveOldest() {
-	for e := c.ll.Back(); e != nil; e = e.Prev() {
-		kv := e.Value.(*entry)
-		if kv.expire.Before(time.Now()) {
-			c.RemoveElement(e)
-			break
+	if e := c.ll.Back(); e != nil {
+		if e.Value.(*entry).expire.Before(time.Now()) {
+			c.removeElement(e, c.OnExpired)
+		} else {
+			c.removeElement(e, c.OnEvicted)
 		}
 	}
 }
 
-// RemoveElement 函数用于删除某个节点
+// RemoveElement 函数用于删除某个节点，并调用 OnEvicted
 func (c *LRUCache) RemoveElement(e *list.Element) {
+	c.removeElement(e, c.OnEvicted)
+}
+
+// removeElement 删除节点，callback 不为 nil 时调用 callback
+func (c *LRUCache) removeElement(e *list.Element, callback func(key string, value Value)) {
 	c.ll.Remove(e)
 	kv := e.Value.(*entry)
 	delete(c.cache, kv.key)                                //删除key-节点这对映射
 	c.nBytes -= int64(len(kv.key)) + int64(kv.value.Len()) //重新计算已用容量
-	if c.OnEvicted != nil {
-		c.OnEvicted(kv.key, kv.value) //调用对应的回调函数
+	if callback != nil {
+		callback(kv.key, kv.value) //调用对应的回调函数
 	}
 }
@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -12,11 +13,11 @@ func (d String) Len() int {
 }
 
 func TestGet(t *testing.T) {
-	lru := New(int64(0), nil, 60)
+	lru := New(int64(0), nil, time.Minute)
 	//在这个特定的上下文中，int64(0) 作为参数传递给 New 函数，用于指定 LRU 缓存的最大存储容量。
 	//在这里，将其设置为 0 表示缓存的最大容量为零，即没有存储空间，因此不会保存任何键值对。
 	//这可以用于创建一个非常小的缓存或用于特定的测试场景，其中不需要实际存储数据。
-	lru.Add("key1", String("1234"), 60)
+	lru.Add("key1", String("1234"), time.Minute)
 	if v, ok := lru.Get("key1"); !ok || string(v.(String)) != "1234" {
 		t.Fatalf("cache hit key1=1234 failed")
 	}
@@ -29,14 +30,17 @@ func TestRemoveoldest(t *testing.T) {
 	k1, k2, k3 := "key1", "key2", "k3"
 	v1, v2, v3 := "value1", "value2", "v3"
 	Cap := len(k1 + k2 + v1 + v2)
-	lru := New(int64(Cap), nil, 60)
-	lru.Add(k1, String(v1), 60)
-	lru.Add(k2, String(v2), 60)
-	lru.Add(k3, String(v3), 60)
+	lru := New(int64(Cap), nil, time.Minute)
+	lru.Add(k1, String(v1), time.Minute)
+	lru.Add(k2, String(v2), time.Minute)
+	lru.Add(k3, String(v3), time.Minute)
 
 	if _, ok := lru.Get("key1"); ok || lru.Len() != 2 {
 		t.Fatalf("Removeoldest key1 failed")
 	}
+	if want := int64(len(k2 + k3 + v2 + v3)); lru.Bytes() != want {
+		t.Fatalf("expected %d bytes, got %d", want, lru.Bytes())
+	}
 }
 
 func TestOnEvicted(t *testing.T) {
@@ -44,11 +48,11 @@ func TestOnEvicted(t *testing.T) {
 	callback := func(key string, value Value) {
 		keys = append(keys, key)
 	}
-	lru := New(int64(10), callback, 60)
-	lru.Add("key1", String("123456"), 60)
-	lru.Add("k2", String("k2"), 60)
-	lru.Add("k3", String("k3"), 60)
-	lru.Add("k4", String("k4"), 60)
+	lru := New(int64(10), callback, time.Minute)
+	lru.Add("key1", String("123456"), time.Minute)
+	lru.Add("k2", String("k2"), time.Minute)
+	lru.Add("k3", String("k3"), time.Minute)
+	lru.Add("k4", String("k4"), time.Minute)
 	expect := []string{"key1", "k2"}
 	if !reflect.DeepEqual(expect, keys) {
 		t.Fatalf("Call onEvicted failed,expect keys equals to %s", expect)
@@ -56,11 +60,67 @@ func TestOnEvicted(t *testing.T) {
 }
 
 func TestAdd(t *testing.T) {
-	lru := New(int64(0), nil, 60)
-	lru.Add("key", String("1"), 60)
-	lru.Add("key", String("111"), 60)
+	lru := New(int64(0), nil, time.Minute)
+	lru.Add("key", String("1"), time.Minute)
+	lru.Add("key", String("111"), time.Minute)
 
 	if lru.nBytes != int64(len("key")+len("111")) {
 		t.Fatal("expected 6 but got", lru.nBytes)
 	}
 }
+
+func TestOnExpired(t *testing.T) {
+	evicted, expired, removed := make([]string, 0), make([]string, 0), make([]string, 0)
+	lru := New(int64(10), func(key string, value Value) { evicted = append(evicted, key) }, time.Minute)
+	lru.OnExpired = func(key string, value Value) { expired = append(expired, key) }
+	lru.OnRemoved = func(key string, value Value) { removed = append(removed, key) }
+	lru.Add("k1", String("k1"), time.Millisecond)
+	lru.Add("k2", String("k2"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := lru.Get("k1"); ok {
+		t.Fatalf("expired key k1 should not be returned")
+	}
+	lru.Add("k3", String("k3"), time.Minute)
+	lru.Add("k4", String("k4"), time.Minute) // 容量不足，淘汰已过期的 k2
+	lru.Add("k5", String("k5"), time.Minute) // 容量不足，淘汰未过期的 k3
+	lru.Remove("k4")
+	if !reflect.DeepEqual(removed, []string{"k4"}) {
+		t.Fatalf("removed=%v", removed)
+	}
+	if !reflect.DeepEqual(expired, []string{"k1", "k2"}) || !reflect.DeepEqual(evicted, []string{"k3"}) {
+		t.Fatalf("expired=%v evicted=%v", expired, evicted)
+	}
+}
+
+func TestSetMaxBytes(t *testing.T) {
+	evicted := make([]string, 0)
+	lru := New(int64(0), func(key string, value Value) { evicted = append(evicted, key) }, time.Minute)
+	lru.Add("k1", String("v1"), time.Minute)
+	lru.Add("k2", String("v2"), time.Minute)
+	lru.Add("k3", String("v3"), time.Minute)
+	lru.Get("k1")
+	lru.SetMaxBytes(8) // 只能容纳两条记录，淘汰最久未使用的 k2
+	if !reflect.DeepEqual(evicted, []string{"k2"}) || lru.Len() != 2 {
+		t.Fatalf("evicted=%v len=%d", evicted, lru.Len())
+	}
+}
+
+func TestPeek(t *testing.T) {
+	evicted := make([]string, 0)
+	lru := New(int64(8), func(key string, value Value) { evicted = append(evicted, key) }, time.Minute)
+	lru.Add("k1", String("v1"), time.Minute)
+	lru.Add("k2", String("v2"), time.Minute)
+	if v, ok := lru.Peek("k1"); !ok || string(v.(String)) != "v1" {
+		t.Fatalf("peek k1 failed")
+	}
+	lru.Add("k3", String("v3"), time.Minute) // Peek 没有移动 k1，k1 仍是最久未使用的记录
+	if !reflect.DeepEqual(evicted, []string{"k1"}) {
+		t.Fatalf("evicted=%v", evicted)
+	}
+
+	lru.Add("k4", String("v4"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, ok := lru.Peek("k4"); ok || lru.Len() != 2 {
+		t.Fatalf("expired key should not be returned or removed by Peek, len=%d", lru.Len())
+	}
+}
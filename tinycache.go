@@ -1,9 +1,11 @@
 package tinycache
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
 	"math"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,6 +23,35 @@ func (f GetterFunc) Get(key string) ([]byte, error) { //函数类型实现某一
 	return f(key)
 }
 
+// Setter 用于把数据写回数据源，配合 WithWriteThrough 或 WithWriteBehind 使用
+type Setter interface {
+	Set(key string, value []byte) error
+}
+
+// SetterFunc 是实现了 Setter 接口的函数类型
+type SetterFunc func(key string, value []byte) error
+
+func (f SetterFunc) Set(key string, value []byte) error {
+	return f(key, value)
+}
+
+// BatchSetter 是 Setter 的可选扩展，write-behind 批量写回时会优先调用 SetBatch
+type BatchSetter interface {
+	SetBatch(values map[string][]byte) error
+}
+
+// Deleter 用于从数据源删除数据，配合 WithDeleter 使用
+type Deleter interface {
+	Delete(key string) error
+}
+
+// DeleterFunc 是实现了 Deleter 接口的函数类型
+type DeleterFunc func(key string) error
+
+func (f DeleterFunc) Delete(key string) error {
+	return f(key)
+}
+
 type Group struct {
 	name      string               // 缓存组的名称。
 	getter    Getter               // 实现了 Getter 接口的对象（回调），从数据源用于获取缓存数据。
@@ -29,6 +60,16 @@ type Group struct {
 	peers     PeerPicker           // 实现了 PeerPicker 接口的对象，用于根据键选择相应的缓存节点
 	loader    *singleflight.Group  // 确保相同请求只被执行一次
 	keys      map[string]*KeyStats // 根据键key获取对应key的统计信息
+	inval     invalidations        // 每个key最近一次失效的序号
+	setter    Setter               // 写回数据源，为 nil 时 Set 只写缓存
+	deleter   Deleter              // 从数据源删除，为 nil 时 Delete 只删除缓存
+	writeBack *writeBehind         // 不为 nil 时为异步回写（write-behind），否则为同步写（write-through）
+	policy    FailurePolicy        // 访问拥有者失败时的处理方式
+	stats     groupCounters        // 命中、加载等统计计数
+	log       *logger              // 日志，默认为 slog.Default()
+	listeners []Listener           // 缓存生命周期事件的监听者
+	hotKeys   *hotKeyTrackers      // 热点 key 统计，为 nil 时不统计
+	setLocks  keyLocks             // 同一个 key 的 Set、SetVersion 和 CompareAndSet 按顺序执行
 } //负责与用户的交互，并且控制缓存值存储和获取的流程。
 
 type AtomicInt int64 // 封装一个原子类，用于进行原子操作，保证并发安全.
@@ -54,18 +95,21 @@ var (
 	groups             = make(map[string]*Group) //map,根据键缓存组的名字，获取对应的缓存组
 )
 
-// NewGroup 函数传入name,acheBytes,CacheType,getter,获取缓存组Group
-func NewGroup(name string, cacheBytes int64, CacheType string, getter Getter) *Group { //增加CacheType,用来选择具体缓存淘汰算法
+// NewGroup 函数传入name,acheBytes,CacheType,getter,获取缓存组Group，opts 用于配置写回数据源等可选功能
+func NewGroup(name string, cacheBytes int64, CacheType string, getter Getter, opts ...GroupOption) *Group { //增加CacheType,用来选择具体缓存淘汰算法
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:   name,
-		getter: getter,
-		loader: &singleflight.Group{},
-		keys:   map[string]*KeyStats{},
+		name:    name,
+		getter:  getter,
+		loader:  &singleflight.Group{},
+		keys:    map[string]*KeyStats{},
+		policy:  DefaultFailurePolicy,
+		log:     newLogger(),
+		hotKeys: newHotKeyTrackers(defaultHotKeyCapacity),
 	}
 	switch CacheType { //根据淘汰算法，实例化mainCache,hotCache
 	case "lru":
@@ -77,6 +121,13 @@ func NewGroup(name string, cacheBytes int64, CacheType string, getter Getter) *G
 	default:
 		panic("Please select the correct algorithm!")
 	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.watchEvictions()
+	if g.writeBack != nil {
+		g.writeBack.start(g.deleter, g.log)
+	}
 	groups[name] = g
 	return g
 }
@@ -89,62 +140,190 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// RemoveGroup 关闭并移除名为 name 的 Group，不存在时返回 false
+func RemoveGroup(name string) bool {
+	g := GetGroup(name)
+	if g == nil {
+		return false
+	}
+	g.Close()
+	return true
+}
+
+// Close 写回回写队列中剩余的操作并停止后台协程，把 Group 从全局注册表中移除，并释放缓存占用的内存。
+// 之后可以用相同的名字重新创建 Group。关闭后不应再使用 g，可以重复调用
+func (g *Group) Close() {
+	mu.Lock()
+	if groups[g.name] == g {
+		delete(groups, g.name)
+	}
+	mu.Unlock()
+
+	if g.writeBack != nil {
+		g.writeBack.close()
+	}
+	g.mainCache.clear()
+	g.hotCache.clear()
+	leases.forgetGroup(g.name)
+	g.inval.mu.Lock()
+	g.inval.seqs = nil
+	g.inval.mu.Unlock()
+}
+
+// allGroups 返回当前所有的缓存组
+func allGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, g)
+	}
+	return res
+}
+
 // Get 函数用于获取缓存数据，获取顺序为：热点缓存、主缓存、数据源
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 同 Get，ctx 中的追踪上下文会传给远程节点，各阶段的耗时记录为 ctx 中 span 的子 span
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	value, _, err := g.getWithSource(ctx, key)
+	return value, err
+}
+
+// GetWithSource 同 Get，同时返回数据来自哪里（热点缓存、主缓存、拥有者、下一个候选节点或本地数据源）
+func (g *Group) GetWithSource(key string) (ByteView, Source, error) {
+	return g.getWithSource(context.Background(), key)
+}
+
+func (g *Group) getWithSource(ctx context.Context, key string) (value ByteView, source Source, err error) {
 	if key == "" {
-		return ByteView{}, fmt.Errorf("key is required")
+		return ByteView{}, 0, fmt.Errorf("key is required")
 	}
-	if v, ok := g.hotCache.get(key); ok {
-		log.Println("[TinyCache] hit hotCache")
-		return v, nil
+	ctx, span := startSpan(ctx, "tinycache.Group.Get")
+	span.SetAttribute("group", g.name)
+	span.SetAttribute("key", key)
+	defer func() {
+		if err == nil {
+			g.hotKeys.returned(key, value)
+		}
+		span.SetAttribute("source", source.String())
+		endSpan(span, err)
+	}()
+
+	g.stats.gets.Add(1)
+	g.hotKeys.get(key)
+	if v, ok := g.lookup(ctx, "tinycache.hotCache", g.hotCache, key); ok {
+		g.stats.hotHits.Add(1)
+		g.log.request("cache hit", "group", g.name, keyHash(key), "cache", "hot")
+		g.notifyHit(key, SourceHotCache)
+		return v, SourceHotCache, nil
 	}
-	if v, ok := g.mainCache.get(key); ok {
-		log.Println("[TinyCache] hit mainCache")
-		return v, nil
+	if v, ok := g.lookup(ctx, "tinycache.mainCache", g.mainCache, key); ok {
+		g.stats.mainHits.Add(1)
+		g.log.request("cache hit", "group", g.name, keyHash(key), "cache", "main")
+		g.notifyHit(key, SourceMainCache)
+		return v, SourceMainCache, nil
 	}
-	return g.load(key)
+	g.hotKeys.miss(key)
+	g.notifyMiss(key)
+	return g.load(ctx, key)
 }
 
-// load 方法的逻辑是首先尝试从远程节点获取数据，如果失败或者没有配置远程节点，则回退到本地获取。
-func (g *Group) load(key string) (value ByteView, err error) {
-	viewi, err := g.loader.Do(key, func() (interface{}, error) { //singleFlight原理，相同请求只执行一次
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok { //根据key选择远程节点
-				if value, err = g.getFromPeer(peer, key); err == nil { //从远程节点获取数据
-					return value, nil
-				}
-				log.Println("[TinyCache] Failed to get from peer", err)
-			}
-		}
-		return g.getLocally(key) //从本地获取缓存数据
+// lookup 在 span 中查找缓存
+func (g *Group) lookup(ctx context.Context, name string, cache BaseCache, key string) (ByteView, bool) {
+	_, span := startSpan(ctx, name)
+	v, ok := cache.get(key)
+	span.SetAttribute("hit", strconv.FormatBool(ok))
+	span.End()
+	return v, ok
+}
+
+// load 方法的逻辑是首先尝试从远程节点获取数据，如果失败或者没有配置远程节点，则按失败处理策略回退，详见 fetch。
+func (g *Group) load(ctx context.Context, key string) (ByteView, Source, error) {
+	ctx, span := startSpan(ctx, "tinycache.Group.load")
+	start, executed := time.Now(), false
+	flight := key
+	if isHedged(ctx) {
+		flight = "\x00hedged/" + key // 对冲请求不能等待正在向拥有者请求的加载
+	}
+	viewi, err := g.loader.Do(flight, func() (interface{}, error) { //singleFlight原理，相同请求只执行一次
+		executed = true
+		value, source, err := g.fetch(ctx, key)
+		return loadResult{value: value, source: source}, err
 	})
-	if err == nil {
-		return viewi.(ByteView), nil
+	if !executed { // 没有执行 fn，说明等待的是其他请求的加载结果
+		g.stats.loadsDeduped.Add(1)
+	}
+	span.SetAttribute("deduped", strconv.FormatBool(!executed))
+	endSpan(span, err)
+	res, _ := viewi.(loadResult)
+	if executed {
+		g.notifyLoad(key, res.source, time.Since(start), err)
 	}
-	return
+	g.log.request("load", "group", g.name, keyHash(key), "source", res.source.String(),
+		"latency", time.Since(start), "deduped", !executed, "err", err)
+	return res.value, res.source, err
 }
 
-// getLocally 从数据源获取数据，然后将数据添加到mainCache中
-func (g *Group) getLocally(key string) (ByteView, error) {
+// getLocally 从数据源获取数据，populate 为 true 时将数据添加到mainCache中
+// 如果加载期间 key 被失效或被写入了新值，则只返回数据而不写入缓存
+func (g *Group) getLocally(ctx context.Context, key string, populate bool) (ByteView, error) {
+	version := g.nextSeq() // 版本号在加载前生成，加载期间的失效和写入都比它新
+	_, span := startSpan(ctx, "tinycache.Getter.Get")
+	start := time.Now()
 	bytes, err := g.getter.Get(key)
+	g.stats.localLoadLatency.observe(time.Since(start))
+	endSpan(span, err)
 	if err != nil {
+		g.stats.localLoadErrors.Add(1)
 		return ByteView{}, err
 	}
-	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value)
+	g.stats.localLoads.Add(1)
+	value := ByteView{b: cloneBytes(bytes), version: version, loadedAt: time.Now()}
+	if populate {
+		g.populateVersioned(key, value, false)
+	}
 	return value, nil
 }
 
-// populateCache 将数据添加到mainCache中
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// countRemote 记录一次从远程节点获取 key，QPS 达到 maxMinuteRemoteQPS 时删除统计并返回 true，表示应存入 hotCache。
+// g.keys 由 mu 保护，并发的远程获取会同时修改它
+func (g *Group) countRemote(key string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	stat, ok := g.keys[key]
+	if !ok {
+		//第一次获取
+		g.keys[key] = &KeyStats{
+			firstGetTime: time.Now(),
+			remoteCnt:    1,
+		}
+		return false
+	}
+	stat.remoteCnt.Add(1)
+	//计算QPS
+	interval := float64(time.Now().Unix()-stat.firstGetTime.Unix()) / 60
+	qps := stat.remoteCnt.Get() / int64(math.Max(1, math.Round(interval)))
+	if qps < int64(maxMinuteRemoteQPS) {
+		return false
+	}
+	//删除映射关系,节省内存
+	delete(g.keys, key)
+	return true
+}
+
+// populateCache 将数据添加到mainCache中，ttl<=0 时使用默认过期时间
+func (g *Group) populateCache(key string, value ByteView, ttl time.Duration) {
+	g.mainCache.add(key, value, ttl)
 }
 
 // populateHotCache 将数据添加到hotCache中
 func (g *Group) populateHotCache(key string, value ByteView) {
 	if g.hotCache != nil {
 		// Add the data to hotCache
-		g.hotCache.add(key, value)
+		g.hotCache.add(key, value, 0)
 	}
 }
 
@@ -158,36 +337,40 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 //这样，在分布式缓存系统的运行过程中，当需要根据键选择远程节点时，可以通过调用 g.peers.PickPeer(key) 来获取合适的远程节点的 PeerGetter 对象。
 
 // getFromPeer 实现了 PeerGetter 接口的 Client 从访问远程节点，获取缓存值。
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	ctx, span := startSpan(ctx, "tinycache.peer.Get")
+	start := time.Now()
+	err := getFromPeerContext(ctx, peer, req, res)
+	if isMessageTooLarge(err) {
+		// 缓存值超过了单条 gRPC 消息的大小限制，改为分段传输
+		var buf bytes.Buffer
+		if meta, ok, serr := getStreamFromPeerContext(ctx, peer, req, &buf); ok {
+			if err = serr; err == nil {
+				res = meta
+				res.Value = buf.Bytes()
+			}
+		}
+	}
+	g.stats.peerLoadLatency.observe(time.Since(start))
+	endSpan(span, err)
 	if err != nil {
+		g.stats.peerErrors.Add(1)
+		g.notifyPeerError(key, err)
 		return ByteView{}, err
 	}
+	g.stats.peerLoads.Add(1)
+	value := ByteView{b: res.Value, version: res.Version, loadedAt: time.Unix(0, res.LoadTime)}
 	//远程获取cnt++
-	if stat, ok := g.keys[key]; ok {
-		stat.remoteCnt.Add(1)
-		//计算QPS
-		interval := float64(time.Now().Unix()-stat.firstGetTime.Unix()) / 60
-		qps := stat.remoteCnt.Get() / int64(math.Max(1, math.Round(interval)))
-		if qps >= int64(maxMinuteRemoteQPS) {
-			//存入hotCache
-			g.populateHotCache(key, ByteView{b: res.Value})
-			//删除映射关系,节省内存
-			mu.Lock()
-			delete(g.keys, key)
-			mu.Unlock()
-		}
-	} else {
-		//第一次获取
-		g.keys[key] = &KeyStats{
-			firstGetTime: time.Now(),
-			remoteCnt:    1,
+	if g.countRemote(key) {
+		//存入hotCache
+		if g.populateVersioned(key, value, true) {
+			g.notifyHotPromote(key, value)
 		}
 	}
-	return ByteView{b: res.Value}, nil
+	return value, nil
 }
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 // 定义一个函数类型 F，并且实现接口 A 的方法，然后在这个方法中调用自己。这是 Go 语言中将其他函数（参数返回值定义与 F 一致）转换为接口 A 的常用技巧。
@@ -79,3 +81,27 @@ func TestGet2(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty,but %s got", view)
 	}
 }
+
+// 并发从远程节点获取不同的key时，远程访问统计不能产生数据竞争
+func TestConcurrentPeerGets(t *testing.T) {
+	loads := 0
+	g := newFailoverGroup("concurrent-peer", DefaultFailurePolicy, &stubPicker{owner: &stubPeer{value: "630", delay: time.Millisecond}}, &loads)
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("key-%d", (i+j)%64)
+				if view, err := g.Get(key); err != nil || view.String() != "630" {
+					t.Errorf("get %s: %v %v", key, view, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if loads != 0 {
+		t.Fatalf("every key should be loaded from the peer, got %d local loads", loads)
+	}
+}
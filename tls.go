@@ -0,0 +1,191 @@
+package tinycache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig 节点间通信的 TLS 配置。证书文件变化后会自动重新加载，不需要重启节点
+type TLSConfig struct {
+	CertFile string // 本节点的证书，同时用作服务端证书和客户端证书
+	KeyFile  string // 本节点的私钥
+	CAFile   string // 用于校验对端证书的 CA 证书
+
+	// ClientAuth 为 true 时服务端要求客户端出示由 CA 签发的证书（mTLS）
+	ClientAuth bool
+	// VerifyClientIdentity 为 true 时，服务端还要求客户端证书中的 IP 或域名属于集群中已知的节点
+	VerifyClientIdentity bool
+}
+
+// WithTLS 为节点间通信启用 TLS。客户端总是校验服务端证书由 CA 签发，且证书中的地址与注册的节点地址一致；
+// HTTPPOOL 的服务端需要使用 HTTPPOOL.TLSConfig 创建 http.Server
+func WithTLS(cfg TLSConfig) Option {
+	return func(o *options) {
+		o.tls = newTLSFiles(cfg)
+	}
+}
+
+// tlsReloadInterval 是检查证书文件是否变化的最短间隔
+var tlsReloadInterval = time.Second
+
+// tlsFiles 保存从文件加载的证书和 CA，握手时按需检查文件的修改时间并重新加载
+type tlsFiles struct {
+	cfg     TLSConfig
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	mods    [3]time.Time // CertFile、KeyFile、CAFile 的修改时间
+	checked time.Time    // 上次检查文件的时间
+	err     error        // 上次加载的错误
+}
+
+func newTLSFiles(cfg TLSConfig) *tlsFiles {
+	return &tlsFiles{cfg: cfg}
+}
+
+// current 返回当前的证书和 CA，距离上次检查超过 tlsReloadInterval 时检查文件是否变化
+func (f *tlsFiles) current() (*tls.Certificate, *x509.CertPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cert != nil && time.Since(f.checked) < tlsReloadInterval {
+		return f.cert, f.pool, nil
+	}
+	f.checked = time.Now()
+
+	var mods [3]time.Time
+	for i, name := range []string{f.cfg.CertFile, f.cfg.KeyFile, f.cfg.CAFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return f.fallback(err)
+		}
+		mods[i] = info.ModTime()
+	}
+	if f.cert != nil && mods == f.mods {
+		return f.cert, f.pool, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.cfg.CertFile, f.cfg.KeyFile)
+	if err != nil {
+		return f.fallback(err)
+	}
+	ca, err := os.ReadFile(f.cfg.CAFile)
+	if err != nil {
+		return f.fallback(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return f.fallback(fmt.Errorf("no certificates found in %s", f.cfg.CAFile))
+	}
+	f.cert, f.pool, f.mods, f.err = &cert, pool, mods, nil
+	return f.cert, f.pool, nil
+}
+
+// fallback 重新加载失败时继续使用已加载的证书（例如证书文件正在被替换），从未加载成功时返回错误。调用方需持有 f.mu
+func (f *tlsFiles) fallback(err error) (*tls.Certificate, *x509.CertPool, error) {
+	f.err = fmt.Errorf("tinycache: load tls files: %w", err)
+	if f.cert != nil {
+		return f.cert, f.pool, nil
+	}
+	return nil, nil, f.err
+}
+
+// serverConfig 返回服务端的 TLS 配置，isPeer 用于校验客户端证书中的地址是否属于集群中的节点
+func (f *tlsFiles) serverConfig(isPeer func(host string) bool) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _, err := f.current()
+			return cert, err
+		},
+	}
+	if !f.cfg.ClientAuth {
+		return cfg
+	}
+	// 证书链由 VerifyConnection 按最新的 CA 校验，CA 文件变化后不需要重建配置
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		leaf, err := f.verifyChain(cs, "", x509.ExtKeyUsageClientAuth)
+		if err != nil {
+			return err
+		}
+		if f.cfg.VerifyClientIdentity && !certMatches(leaf, isPeer) {
+			return fmt.Errorf("tinycache: client certificate %q does not belong to a known peer", leaf.Subject.CommonName)
+		}
+		return nil
+	}
+	return cfg
+}
+
+// clientConfig 返回访问 host 的客户端 TLS 配置，服务端证书必须由 CA 签发且包含 host
+func (f *tlsFiles) clientConfig(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // 证书链和地址由 VerifyConnection 按最新的 CA 校验
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _, err := f.current()
+			return cert, err
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			_, err := f.verifyChain(cs, host, x509.ExtKeyUsageServerAuth)
+			return err
+		},
+	}
+}
+
+// verifyChain 用当前的 CA 校验对端证书链，host 不为空时同时校验证书中的地址
+func (f *tlsFiles) verifyChain(cs tls.ConnectionState, host string, usage x509.ExtKeyUsage) (*x509.Certificate, error) {
+	if len(cs.PeerCertificates) == 0 {
+		return nil, errors.New("tinycache: peer did not present a certificate")
+	}
+	_, pool, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	leaf := cs.PeerCertificates[0]
+	if _, err = leaf.Verify(opts); err != nil {
+		return nil, fmt.Errorf("tinycache: verify peer certificate: %w", err)
+	}
+	return leaf, nil
+}
+
+// certMatches 判断证书中的 IP 或域名是否有一个属于集群中的节点
+func certMatches(cert *x509.Certificate, isPeer func(host string) bool) bool {
+	for _, ip := range cert.IPAddresses {
+		if isPeer(ip.String()) {
+			return true
+		}
+	}
+	for _, name := range cert.DNSNames {
+		if isPeer(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerHost 返回节点地址中的主机部分，地址可以是 ip:port，也可以是 http://ip:port 形式的 URL
+func peerHost(addr string) string {
+	if u, err := url.Parse(addr); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
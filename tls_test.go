@@ -0,0 +1,164 @@
+package tinycache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// testCA 用于在测试中签发证书
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tinycache test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	writePEM(t, filepath.Join(ca.dir, "ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+// issue 签发一个包含 ip 的节点证书，写入 name.pem 和 name-key.pem，返回对应的 TLSConfig
+func (ca *testCA) issue(t *testing.T, name, ip string, serial int64) TLSConfig {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	cfg := TLSConfig{
+		CertFile: filepath.Join(ca.dir, name+".pem"),
+		KeyFile:  filepath.Join(ca.dir, name+"-key.pem"),
+		CAFile:   filepath.Join(ca.dir, "ca.pem"),
+	}
+	writePEM(t, cfg.CertFile, "CERTIFICATE", der)
+	writePEM(t, cfg.KeyFile, "EC PRIVATE KEY", keyDER)
+	return cfg
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCMutualTLS(t *testing.T) {
+	NewGroup("mtls", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	ca := newTestCA(t)
+	node := ca.issue(t, "node", "127.0.0.1", 2)
+	node.ClientAuth, node.VerifyClientIdentity = true, true
+	stranger := ca.issue(t, "stranger", "10.9.9.9", 3) // 同一个CA签发，但不是集群中的节点
+	other := newTestCA(t).issue(t, "other", "127.0.0.1", 4)
+
+	addr, _ := startTransports(t, WithTLS(node))
+	in := &pb.Request{Group: "mtls", Key: "Tom"}
+	out := &pb.Response{}
+	if err := peerClient(addr, WithTLS(node)).Get(in, out); err != nil || string(out.Value) != "630" {
+		t.Fatalf("peer with a valid certificate should be accepted, got %q %v", out.Value, err)
+	}
+	for name, c := range map[string]*Client{
+		"plaintext":       peerClient(addr),
+		"unknown peer":    peerClient(addr, WithTLS(stranger)),
+		"untrusted chain": peerClient(addr, WithTLS(other)),
+	} {
+		if err := c.Get(in, &pb.Response{}); err == nil {
+			t.Fatalf("%s client should be rejected", name)
+		}
+	}
+}
+
+func TestHTTPTLS(t *testing.T) {
+	NewGroup("https", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	ca := newTestCA(t)
+	node := ca.issue(t, "node", "127.0.0.1", 2)
+	node.ClientAuth = true
+
+	pool := NewHTTPPool("https://127.0.0.1", WithTLS(node))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: pool, TLSConfig: pool.TLSConfig()}
+	go srv.ServeTLS(lis, "", "")
+	defer srv.Close()
+	baseURL := "https://" + lis.Addr().String() + defaultPath
+
+	o := defaultOptions()
+	WithTLS(node)(&o)
+	out := &pb.Response{}
+	if err := newHTTPGetter(baseURL, o).Get(&pb.Request{Group: "https", Key: "Tom"}, out); err != nil || string(out.Value) != "630" {
+		t.Fatalf("https get: %q %v", out.Value, err)
+	}
+	if err := newHTTPGetter(baseURL, defaultOptions()).Get(&pb.Request{Group: "https", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("client without a certificate should be rejected")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	defer func(d time.Duration) { tlsReloadInterval = d }(tlsReloadInterval)
+	tlsReloadInterval = 0
+
+	ca := newTestCA(t)
+	files := newTLSFiles(ca.issue(t, "node", "127.0.0.1", 2))
+	first, _, err := files.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := ca.issue(t, "node", "127.0.0.1", 3) // 覆盖同名的证书文件
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.CertFile, later, later)
+	second, _, err := files.current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(second.Certificate[0])
+	if second == first || leaf.SerialNumber.Int64() != 3 {
+		t.Fatalf("changed certificate files should be reloaded, got serial %v", leaf.SerialNumber)
+	}
+
+	// 文件暂时不可用时继续使用已加载的证书
+	os.Remove(cfg.KeyFile)
+	if third, _, err := files.current(); err != nil || third != second {
+		t.Fatalf("missing files should keep the loaded certificate, got %v", err)
+	}
+}
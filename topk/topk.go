@@ -0,0 +1,110 @@
+// Package topk 用 space-saving 算法统计数据流中出现次数（或权重）最大的 key，内存占用固定为 capacity 个计数器
+package topk
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// Counter 是一个 key 的计数，真实计数在 [Count-Error, Count] 之间
+type Counter struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"` // 估计的计数，不小于真实计数
+	Error int64  `json:"error"` // 最大高估值，即这个 key 替换掉上一个 key 时继承的计数
+}
+
+// Tracker 记录计数最大的至多 capacity 个 key。计数大于总数 1/capacity 的 key 一定会被记录。并发安全
+type Tracker struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*item
+	heap     minHeap // 按 Count 排序的小根堆，堆顶是计数最小、下一个被替换的 key
+}
+
+// New 创建一个最多记录 capacity 个 key 的 Tracker
+func New(capacity int) *Tracker {
+	if capacity <= 0 {
+		panic("topk: capacity must be positive")
+	}
+	return &Tracker{capacity: capacity, items: make(map[string]*item, capacity)}
+}
+
+// Add 把 key 的计数增加 weight
+func (t *Tracker) Add(key string, weight int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if it, ok := t.items[key]; ok {
+		it.Count += weight
+		heap.Fix(&t.heap, it.index)
+		return
+	}
+	if len(t.heap) < t.capacity {
+		it := &item{Counter: Counter{Key: key, Count: weight}}
+		t.items[key] = it
+		heap.Push(&t.heap, it)
+		return
+	}
+	// 替换计数最小的 key，新 key 继承它的计数作为误差
+	it := t.heap[0]
+	delete(t.items, it.Key)
+	it.Key, it.Error, it.Count = key, it.Count, it.Count+weight
+	t.items[key] = it
+	heap.Fix(&t.heap, 0)
+}
+
+// Top 按计数从大到小返回至多 n 个 key，n<=0 时返回全部
+func (t *Tracker) Top(n int) []Counter {
+	t.mu.Lock()
+	counters := make([]Counter, len(t.heap))
+	for i, it := range t.heap {
+		counters[i] = it.Counter
+	}
+	t.mu.Unlock()
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Count != counters[j].Count {
+			return counters[i].Count > counters[j].Count
+		}
+		return counters[i].Key < counters[j].Key
+	})
+	if n > 0 && len(counters) > n {
+		counters = counters[:n]
+	}
+	return counters
+}
+
+// Reset 清空所有计数
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	t.items = make(map[string]*item, t.capacity)
+	t.heap = nil
+	t.mu.Unlock()
+}
+
+type item struct {
+	Counter
+	index int // 在堆中的下标
+}
+
+type minHeap []*item
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h minHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *minHeap) Push(x interface{}) {
+	it := x.(*item)
+	it.index = len(*h)
+	*h = append(*h, it)
+}
+
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,51 @@
+package topk
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTop(t *testing.T) {
+	tr := New(3)
+	tr.Add("a", 5)
+	tr.Add("b", 3)
+	tr.Add("c", 1)
+	tr.Add("a", 1)
+
+	want := []Counter{{Key: "a", Count: 6}, {Key: "b", Count: 3}}
+	if got := tr.Top(2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// 已满时替换计数最小的 c，d 继承它的计数作为误差
+	tr.Add("d", 1)
+	want = []Counter{{Key: "a", Count: 6}, {Key: "b", Count: 3}, {Key: "d", Count: 2, Error: 1}}
+	if got := tr.Top(0); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	tr.Reset()
+	if got := tr.Top(0); len(got) != 0 {
+		t.Fatalf("expected no counters after Reset, got %+v", got)
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	tr := New(10)
+	// hot 占总数的 1/3，其余 key 各出现一次，hot 一定排在第一位且误差不超过总数/capacity
+	for i := 0; i < 3000; i++ {
+		if i%3 == 0 {
+			tr.Add("hot", 1)
+		} else {
+			tr.Add(fmt.Sprintf("cold%d", i), 1)
+		}
+	}
+	top := tr.Top(1)
+	if len(top) != 1 || top[0].Key != "hot" || top[0].Count < 1000 || top[0].Count-top[0].Error > 1000 {
+		t.Fatalf("unexpected top counter: %+v", top)
+	}
+	if top[0].Error > 3000/10 {
+		t.Fatalf("error %d exceeds bound", top[0].Error)
+	}
+}
@@ -0,0 +1,237 @@
+package tinycache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SpanContext 标识一个 span，在节点之间按 W3C Trace Context 的 traceparent 格式传递
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid 判断 sc 是否包含有效的 trace id 和 span id
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// String 返回 traceparent 格式的字符串，例如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) String() string {
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-01"
+}
+
+// parseTraceparent 解析 traceparent，格式不正确时返回 false
+func parseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.IsValid()
+}
+
+// Tracer 用于创建 span，可以实现这个接口对接 OpenTelemetry 等追踪系统
+type Tracer interface {
+	// Start 创建名为 name 的 span，parent 无效时开始一个新的 trace
+	Start(parent SpanContext, name string) Span
+}
+
+// Span 是一次操作的追踪记录
+type Span interface {
+	Context() SpanContext
+	SetAttribute(key, value string)
+	RecordError(err error)
+	End()
+}
+
+// tracerHolder 包装 Tracer，使 atomic.Value 中存放的类型保持一致
+type tracerHolder struct {
+	Tracer
+}
+
+var tracer atomic.Value // tracerHolder
+
+// SetTracer 设置全局的 Tracer，t 为 nil 时关闭追踪。没有设置 Tracer 的节点仍会把收到的追踪上下文传给下游节点
+func SetTracer(t Tracer) {
+	tracer.Store(tracerHolder{t})
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext 返回携带 sc 的 ctx，之后创建的 span 以 sc 为父 span
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext 返回 ctx 中当前的 SpanContext，没有时返回无效的 SpanContext
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// startSpan 以 ctx 中的 span 为父 span 创建新的 span，返回携带新 span 的 ctx。没有设置 Tracer 时返回空操作的 span
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	h, _ := tracer.Load().(tracerHolder)
+	if h.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	span := h.Start(SpanContextFromContext(ctx), name)
+	return ContextWithSpanContext(ctx, span.Context()), span
+}
+
+// endSpan 记录 err（不为 nil 时）并结束 span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) Context() SpanContext           { return SpanContext{} }
+func (noopSpan) SetAttribute(key, value string) {}
+func (noopSpan) RecordError(err error)          {}
+func (noopSpan) End()                           {}
+
+// traceparentHeader 是在 gRPC metadata 和 HTTP 请求头中传递追踪上下文的字段
+const traceparentHeader = "traceparent"
+
+// outgoingTraceContext 把 ctx 中的追踪上下文写入 gRPC 请求的 metadata
+func outgoingTraceContext(ctx context.Context) context.Context {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		return metadata.AppendToOutgoingContext(ctx, traceparentHeader, sc.String())
+	}
+	return ctx
+}
+
+// incomingTraceContext 从 gRPC 请求的 metadata 中读取追踪上下文
+func incomingTraceContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(traceparentHeader); len(values) > 0 {
+		if sc, ok := parseTraceparent(values[0]); ok {
+			return ContextWithSpanContext(ctx, sc)
+		}
+	}
+	return ctx
+}
+
+// injectTraceHeader 把 ctx 中的追踪上下文写入 HTTP 请求头
+func injectTraceHeader(ctx context.Context, header http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		header.Set(traceparentHeader, sc.String())
+	}
+}
+
+// extractTraceHeader 从 HTTP 请求头中读取追踪上下文
+func extractTraceHeader(r *http.Request) context.Context {
+	if sc, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+		return ContextWithSpanContext(r.Context(), sc)
+	}
+	return r.Context()
+}
+
+// RecordedSpan 是 TraceRecorder 记录下来的已结束的 span
+type RecordedSpan struct {
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext // 父 span，无效时表示根 span
+	Attributes map[string]string
+	Err        error
+	Start      time.Time
+	End        time.Time
+}
+
+// TraceRecorder 是把 span 保存在内存中的 Tracer，用于测试和调试
+type TraceRecorder struct {
+	mu    sync.Mutex
+	spans []RecordedSpan
+}
+
+// NewTraceRecorder 创建一个 TraceRecorder，配合 SetTracer 使用
+func NewTraceRecorder() *TraceRecorder {
+	return &TraceRecorder{}
+}
+
+func (r *TraceRecorder) Start(parent SpanContext, name string) Span {
+	s := &recordingSpan{recorder: r, data: RecordedSpan{Name: name, Parent: parent, Attributes: map[string]string{}, Start: time.Now()}}
+	if parent.IsValid() {
+		s.data.Context.TraceID = parent.TraceID
+	} else {
+		rand.Read(s.data.Context.TraceID[:])
+	}
+	rand.Read(s.data.Context.SpanID[:])
+	return s
+}
+
+// Spans 按结束顺序返回所有已结束的 span
+func (r *TraceRecorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+// Reset 清空已记录的 span
+func (r *TraceRecorder) Reset() {
+	r.mu.Lock()
+	r.spans = nil
+	r.mu.Unlock()
+}
+
+// recordingSpan 是 TraceRecorder 创建的 span，End 时保存到 recorder 中
+type recordingSpan struct {
+	recorder *TraceRecorder
+	mu       sync.Mutex
+	data     RecordedSpan
+	ended    bool
+}
+
+func (s *recordingSpan) Context() SpanContext {
+	return s.data.Context
+}
+
+func (s *recordingSpan) SetAttribute(key, value string) {
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *recordingSpan) RecordError(err error) {
+	s.mu.Lock()
+	s.data.Err = err
+	s.mu.Unlock()
+}
+
+func (s *recordingSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]string, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mu.Unlock()
+
+	s.recorder.mu.Lock()
+	s.recorder.spans = append(s.recorder.spans, data)
+	s.recorder.mu.Unlock()
+}
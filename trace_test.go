@@ -0,0 +1,92 @@
+package tinycache
+
+import (
+	"context"
+	"testing"
+	pb "tinycache/tinycachepb"
+)
+
+// renamedPeer 把请求转发给另一个 Group，使同一进程中的两个 Group 分别扮演调用方和拥有者
+type renamedPeer struct {
+	peer  PeerGetter
+	group string
+}
+
+func (p renamedPeer) Get(in *pb.Request, out *pb.Response) error {
+	return p.GetContext(context.Background(), in, out)
+}
+
+func (p renamedPeer) GetContext(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return getFromPeerContext(ctx, p.peer, &pb.Request{Group: p.group, Key: in.Key}, out)
+}
+
+func TestTraceparent(t *testing.T) {
+	sc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || sc.String() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("traceparent should round trip, got %v %v", sc, ok)
+	}
+	for _, bad := range []string{"", "00-xyz-00f067aa0ba902b7-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Fatalf("%q should be rejected", bad)
+		}
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := NewTraceRecorder()
+	SetTracer(recorder)
+	defer SetTracer(nil)
+
+	NewGroup("trace-owner", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	grpcAddr, httpURL := startTransports(t)
+	o := defaultOptions()
+	WithDirectDial()(&o)
+	peers := map[string]struct {
+		peer   PeerGetter
+		server string
+		key    string // 每种传输方式使用不同的key，拥有者都需要从数据源加载
+	}{
+		"grpc": {newClient("geecache/"+grpcAddr, o), "tinycache.Server.Get", "Tom"},
+		"http": {newHTTPGetter(httpURL+defaultPath, o), "tinycache.HTTPPOOL.Get", "Jack"},
+	}
+	for name, tc := range peers {
+		recorder.Reset()
+		g := NewGroup("trace-"+name, 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+		g.RegisterPeers(&stubPicker{owner: renamedPeer{peer: tc.peer, group: "trace-owner"}})
+		if v, err := g.Get(tc.key); err != nil || v.String() != db[tc.key] {
+			t.Fatalf("%s get: %v %v", name, v, err)
+		}
+
+		spans := map[string][]RecordedSpan{}
+		for _, s := range recorder.Spans() {
+			spans[s.Name] = append(spans[s.Name], s)
+		}
+		// 调用方和拥有者各有一个 Group.Get，按结束顺序拥有者的先结束
+		gets, peer, server := spans["tinycache.Group.Get"], spans["tinycache.peer.Get"], spans[tc.server]
+		if len(gets) != 2 || len(peer) != 1 || len(server) != 1 || len(spans["tinycache.Getter.Get"]) != 1 {
+			t.Fatalf("%s: unexpected spans %v", name, recorder.Spans())
+		}
+		root, owner := gets[1], gets[0]
+		if root.Parent.IsValid() || root.Attributes["source"] != SourcePeer.String() {
+			t.Fatalf("%s: caller Get should be the root span served by the owner, got %+v", name, root)
+		}
+		for _, s := range recorder.Spans() {
+			if s.Context.TraceID != root.Context.TraceID {
+				t.Fatalf("%s: span %s belongs to another trace", name, s.Name)
+			}
+		}
+		if server[0].Parent != peer[0].Context || owner.Parent != server[0].Context {
+			t.Fatalf("%s: remote spans should be children of the peer call", name)
+		}
+		if load := spans["tinycache.Group.load"]; len(load) != 2 || spans["tinycache.Getter.Get"][0].Parent != load[0].Context || load[0].Parent != owner.Context {
+			t.Fatalf("%s: the owner should call the Getter inside its load span", name)
+		}
+		if hot := spans["tinycache.hotCache"]; len(hot) != 2 || hot[0].Attributes["hit"] != "false" {
+			t.Fatalf("%s: each Group.Get should look up hotCache, got %v", name, hot)
+		}
+	}
+}
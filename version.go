@@ -0,0 +1,203 @@
+package tinycache
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+// SetOption 用于配置 Set、SetVersion 和 CompareAndSet 的单次写入
+type SetOption func(*setOptions)
+
+type setOptions struct {
+	ttl time.Duration // 缓存过期时间，<=0 使用 Group 的默认过期时间
+}
+
+// WithTTL 设置本次写入的缓存过期时间
+func WithTTL(ttl time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.ttl = ttl
+	}
+}
+
+func applySetOptions(opts []SetOption) setOptions {
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Set 把 key 的值写入本节点的 mainCache，并分配一个比当前所有版本都新的版本号。
+// 配置了 WithWriteThrough 时先同步写数据源，写失败则不写缓存；
+// 配置了 WithWriteBehind 时写操作进入回写队列，队列已满则返回错误。
+// 写入后按新的版本号向其他节点广播失效，删除它们 hotCache 和 mainCache 中的旧副本。
+// 返回写入的版本号；广播失败时同时返回错误，此时本地的写入已经生效
+func (g *Group) Set(key string, value []byte, opts ...SetOption) (uint64, error) {
+	if key == "" {
+		return 0, fmt.Errorf("key is required")
+	}
+	version, err := g.set(key, value, applySetOptions(opts).ttl)
+	if err != nil {
+		return 0, err
+	}
+	return version, g.invalidatePeers(key, version)
+}
+
+// set 写数据源并写入本节点的缓存，返回新的版本号
+func (g *Group) set(key string, value []byte, ttl time.Duration) (uint64, error) {
+	release := func() {}
+	defer func() { release() }() // key 的写锁释放之后才通知写入缓存时的删除事件，监听者可以再写入同一个 key
+	defer g.setLocks.lock(key)()
+	if err := g.writeSource(key, value); err != nil {
+		return 0, err
+	}
+	release = g.holdEvictions() // 写数据源期间不暂停通知
+	return g.publish(key, value, ttl), nil
+}
+
+// publish 在数据源写入完成后把 value 写入 mainCache 并删除 hotCache 中的旧副本，返回新的版本号。
+// 版本号在写入数据源之后才分配，比写入期间发起的加载都新，这些加载读到的旧值不会覆盖它
+func (g *Group) publish(key string, value []byte, ttl time.Duration) uint64 {
+	defer g.lockInval()()
+	version := g.nextSeqLocked()
+	g.hotCache.remove(key)
+	g.populateLocked(key, ByteView{b: cloneBytes(value), version: version, loadedAt: time.Now()}, false, ttl)
+	return version
+}
+
+// SetVersion 按给定的版本号写入 key 的值（只写缓存，不写数据源，用于应用已经持久化的版本），只有当 version 比缓存中的版本新、且 key 没有在该版本之后被失效时才会写入，
+// 版本相同时保留字节序更大的值。这样多个写入者并发写入时，无论到达顺序如何，各节点最终保留的值都相同。
+// 返回是否写入成功
+func (g *Group) SetVersion(key string, value []byte, version uint64, opts ...SetOption) (bool, error) {
+	if key == "" {
+		return false, fmt.Errorf("key is required")
+	}
+	defer g.holdEvictions()() // key 的写锁释放之后才通知删除事件
+	defer g.setLocks.lock(key)()
+	defer g.lockInval()()
+	if version > g.inval.clock {
+		g.inval.clock = version
+	}
+	ok := g.populateLocked(key, ByteView{b: cloneBytes(value), version: version, loadedAt: time.Now()}, false, applySetOptions(opts).ttl)
+	if ok {
+		g.hotCache.remove(key)
+	}
+	return ok, nil
+}
+
+// CompareAndSet 只有当 key 在 mainCache 中的当前版本等于 expectedVersion 时才写入新值（expectedVersion 为 0 表示 key 不在缓存中）。
+// 比较在 key 的拥有者上执行：拥有者是远程节点且支持 PeerSetter 时转发给它，否则在本节点执行。
+// 版本匹配时按 Set 的方式写数据源并广播失效。写入成功时返回新的版本号和 true，版本不匹配时返回当前版本号和 false
+func (g *Group) CompareAndSet(key string, expectedVersion uint64, value []byte, opts ...SetOption) (uint64, bool, error) {
+	if key == "" {
+		return 0, false, fmt.Errorf("key is required")
+	}
+	ttl := applySetOptions(opts).ttl
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			if setter, ok := peer.(PeerSetter); ok {
+				res, err := setter.Set(&pb.SetRequest{
+					Group:   g.name,
+					Key:     key,
+					Value:   value,
+					TtlMs:   ttl.Milliseconds(),
+					Options: &pb.SetOptions{CompareAndSet: true, ExpectedVersion: expectedVersion},
+				})
+				if err != nil {
+					return 0, false, err
+				}
+				return res.Version, res.Applied, nil
+			}
+		}
+	}
+	return g.compareAndSetLocally(key, expectedVersion, value, ttl)
+}
+
+// compareAndSetLocally 在本节点执行 CompareAndSet
+func (g *Group) compareAndSetLocally(key string, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, bool, error) {
+	version, applied, err := g.compareAndSet(key, expectedVersion, value, ttl)
+	if err != nil || !applied {
+		return version, applied, err
+	}
+	return version, true, g.invalidatePeers(key, version)
+}
+
+// compareAndSet 比较 key 的当前版本并写入，不广播失效
+func (g *Group) compareAndSet(key string, expectedVersion uint64, value []byte, ttl time.Duration) (uint64, bool, error) {
+	release := func() {}
+	defer func() { release() }() // 同 set，key 的写锁释放之后才通知删除事件
+	defer g.setLocks.lock(key)() // 其他写入者在写数据源期间不能修改 key，比较和写入对它们是原子的
+	var current uint64
+	if v, ok := g.mainCache.peek(key); ok { // 比较不算一次访问，不改变淘汰顺序
+		current = v.version
+	}
+	if current != expectedVersion {
+		return current, false, nil
+	}
+	if err := g.writeSource(key, value); err != nil {
+		return current, false, err
+	}
+	release = g.holdEvictions()
+	return g.publish(key, value, ttl), true, nil
+}
+
+// Delete 从数据源删除 key（配置了 Deleter 时，按同步或异步回写的模式执行），并在整个集群中失效 key 的缓存副本
+func (g *Group) Delete(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.deleter != nil {
+		var err error
+		if g.writeBack != nil {
+			err = g.writeBack.enqueue(writeOp{key: key, delete: true})
+		} else {
+			err = g.deleter.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return g.Invalidate(key)
+}
+
+// FlushWrites 立即把回写队列中的所有操作写回数据源，返回时之前的写操作都已处理完毕。
+// 没有配置 WithWriteBehind 时什么也不做。关闭服务前应调用它，避免丢失还在队列中的写操作
+func (g *Group) FlushWrites() {
+	g.flushWrites(context.Background())
+}
+
+// flushWrites 同 FlushWrites，ctx 结束时不再等待并返回 ctx.Err()
+func (g *Group) flushWrites(ctx context.Context) error {
+	if g.writeBack == nil {
+		return nil
+	}
+	return g.writeBack.flush(ctx)
+}
+
+// keyLocks 按 key 的哈希分段加锁，同一个 key 的写入（包括写数据源）按顺序执行，
+// 不同 key 的写入和所有的加载、失效都不会被数据源的写入阻塞
+type keyLocks [64]sync.Mutex
+
+// lock 锁住 key 所在的分段，返回解锁函数
+func (l *keyLocks) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	m := &l[h.Sum32()%uint32(len(l))]
+	m.Lock()
+	return m.Unlock
+}
+
+// writeSource 按配置的模式把数据写回数据源，调用方需持有 key 的写锁，不能持有 g.inval.mu
+func (g *Group) writeSource(key string, value []byte) error {
+	if g.setter == nil {
+		return nil
+	}
+	if g.writeBack != nil {
+		return g.writeBack.enqueue(writeOp{key: key, value: cloneBytes(value)})
+	}
+	return g.setter.Set(key, value)
+}
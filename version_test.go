@@ -0,0 +1,173 @@
+package tinycache
+
+import (
+	"testing"
+	"time"
+	pb "tinycache/tinycachepb"
+)
+
+func TestSetVersion(t *testing.T) {
+	g := NewGroup("version", 2<<10, "lru", GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	loaded, _ := g.Get("Tom")
+	if loaded.Version() == 0 || loaded.LoadTime().IsZero() {
+		t.Fatalf("loaded value should carry version and load time")
+	}
+	v1, _ := g.Set("Tom", []byte("700"))
+	if v1 <= loaded.Version() {
+		t.Fatalf("Set should assign a newer version, got %d <= %d", v1, loaded.Version())
+	}
+	if view, _ := g.Get("Tom"); view.String() != "700" || view.Version() != v1 {
+		t.Fatalf("expected 700@%d, got %s@%d", v1, view, view.Version())
+	}
+
+	// 旧版本的写入会被丢弃
+	if ok, _ := g.SetVersion("Tom", []byte("old"), v1-1); ok {
+		t.Fatalf("older version should be rejected")
+	}
+	// 版本相同时，保留字节序更大的值，与到达顺序无关
+	g.SetVersion("Jack", []byte("a"), 100)
+	g.SetVersion("Jack", []byte("b"), 100)
+	g.SetVersion("Jack", []byte("a"), 100)
+	if view, _ := g.Get("Jack"); view.String() != "b" {
+		t.Fatalf("tie should be resolved deterministically, got %s", view)
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	g := NewGroup("cas", 2<<10, "lfu", GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	v1, ok, _ := g.CompareAndSet("Sam", 0, []byte("1"))
+	if !ok {
+		t.Fatalf("CAS on missing key with expected version 0 should succeed")
+	}
+	if cur, ok, _ := g.CompareAndSet("Sam", v1-1, []byte("2")); ok || cur != v1 {
+		t.Fatalf("CAS with wrong version should fail and report %d, got %d", v1, cur)
+	}
+	if _, ok, _ := g.CompareAndSet("Sam", v1, []byte("3")); !ok {
+		t.Fatalf("CAS with current version should succeed")
+	}
+	if view, _ := g.Get("Sam"); view.String() != "3" {
+		t.Fatalf("expected 3, got %s", view)
+	}
+}
+
+// 同步写数据源期间不阻塞其他 key 的加载和失效，写入期间发起的加载读到的旧值不会覆盖新值
+func TestSetSlowSource(t *testing.T) {
+	src := newStore()
+	src.data["Tom"] = "old"
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := SetterFunc(func(key string, value []byte) error {
+		close(entered)
+		<-release
+		return src.Set(key, value)
+	})
+	g := NewGroup("version-slow", 2<<10, "lru", GetterFunc(func(key string) ([]byte, error) {
+		if key == "Jack" {
+			return []byte("589"), nil
+		}
+		return src.Get(key)
+	}), WithWriteThrough(slow))
+
+	set := make(chan uint64, 1)
+	go func() {
+		version, _ := g.Set("Tom", []byte("new"))
+		set <- version
+	}()
+	<-entered
+
+	done := make(chan struct{})
+	go func() {
+		g.Get("Jack")
+		g.Invalidate("Sam")
+		g.Get("Tom") // 读到数据源中的旧值并写入缓存
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("loads and invalidations should not wait for the source write")
+	}
+	close(release)
+	version := <-set
+	if view, _ := g.Get("Tom"); view.String() != "new" || view.Version() != version {
+		t.Fatalf("expected new@%d, got %s@%d", version, view, view.Version())
+	}
+}
+
+// Set 和 CompareAndSet 按新的版本号广播失效，其他节点 hotCache 中的旧副本会被删除
+func TestSetInvalidatesPeers(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+	writer := NewGroup("version-writer", 2<<10, "lru", getter)
+	reader := NewGroup("version-reader", 2<<10, "lru", getter)
+	peers := &fakePeers{}
+	writer.RegisterPeers(peers)
+
+	reader.populateVersioned("Tom", ByteView{b: []byte("630"), version: 1}, true)
+	version, err := writer.Set("Tom", []byte("700"))
+	if err != nil || len(peers.reqs) != 1 || peers.reqs[0].Key != "Tom" || peers.reqs[0].Seq != version {
+		t.Fatalf("Set should broadcast an invalidation at the new version, got %v %v", peers.reqs, err)
+	}
+	reader.applyRemoteInvalidation("Tom", peers.reqs[0].Seq)
+	if _, ok := reader.hotCache.peek("Tom"); ok {
+		t.Fatalf("the stale hot copy on the other node should be removed")
+	}
+	if view, _ := writer.Get("Tom"); view.String() != "700" || view.Version() != version {
+		t.Fatalf("the writer should keep its own copy, got %s@%d", view, view.Version())
+	}
+
+	cas, ok, err := writer.CompareAndSet("Tom", version, []byte("701"))
+	if !ok || err != nil || len(peers.reqs) != 2 || peers.reqs[1].Seq != cas {
+		t.Fatalf("CompareAndSet should broadcast an invalidation at the new version, got %v %v", peers.reqs, err)
+	}
+	if _, ok, _ = writer.CompareAndSet("Tom", version, []byte("702")); ok || len(peers.reqs) != 2 {
+		t.Fatalf("a failed CompareAndSet should not broadcast, got %v", peers.reqs)
+	}
+}
+
+// setterPeer 把 Set 请求交给拥有者节点上的 Group 处理
+type setterPeer struct {
+	owner *Group
+}
+
+func (p *setterPeer) Get(in *pb.Request, out *pb.Response) error {
+	view, err := p.owner.Get(in.Key)
+	out.Value, out.Version = view.ByteSlice(), view.Version()
+	return err
+}
+
+func (p *setterPeer) Set(in *pb.SetRequest) (*pb.SetResponse, error) {
+	version, applied, err := p.owner.compareAndSetLocally(in.Key, in.Options.ExpectedVersion, in.Value, time.Duration(in.TtlMs)*time.Millisecond)
+	return &pb.SetResponse{Version: version, Applied: applied}, err
+}
+
+// CompareAndSet 在拥有者上比较版本，非拥有者本地的旧副本不影响结果
+func TestCompareAndSetOnOwner(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+	owner := NewGroup("cas-owner", 2<<10, "lru", getter)
+	front := NewGroup("cas-front", 2<<10, "lru", getter)
+	front.RegisterPeers(&stubPicker{owner: &setterPeer{owner: owner}})
+
+	v1, _ := owner.Set("Sam", []byte("1"))
+	front.populateVersioned("Sam", ByteView{b: []byte("0"), version: v1 - 1}, false) // 非拥有者上的旧副本
+	if cur, ok, _ := front.CompareAndSet("Sam", v1-1, []byte("2")); ok || cur != v1 {
+		t.Fatalf("CAS should compare against the owner's version %d, got %d %v", v1, cur, ok)
+	}
+	v2, ok, err := front.CompareAndSet("Sam", v1, []byte("3"))
+	if !ok || err != nil {
+		t.Fatalf("CAS with the owner's version should succeed: %v", err)
+	}
+	if view, _ := owner.mainCache.peek("Sam"); view.String() != "3" || view.Version() != v2 {
+		t.Fatalf("CAS should be applied on the owner, got %s@%d", view, view.Version())
+	}
+}
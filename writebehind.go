@@ -0,0 +1,224 @@
+package tinycache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WriteBehindConfig 是异步回写（write-behind）队列的配置，零值字段使用默认值
+type WriteBehindConfig struct {
+	QueueSize     int                         // 队列容量，队列满时 Set 返回错误，默认 1024
+	BatchSize     int                         // 每批写入的最大条数，默认 100
+	FlushInterval time.Duration               // 未攒满一批时的最长等待时间，默认 1s
+	MaxRetries    int                         // 写入失败后的最大重试次数，默认 3，小于0时不重试
+	RetryBackoff  time.Duration               // 第一次重试前的等待时间，之后每次翻倍，默认 100ms
+	OnError       func(key string, err error) // 重试耗尽后仍失败时的回调，批量写入失败时对批中的每个 key 各调用一次，可以为 nil
+}
+
+// withDefaults 为零值字段填充默认值
+func (c WriteBehindConfig) withDefaults() WriteBehindConfig {
+	if c.QueueSize <= 0 {
+		c.QueueSize = 1024
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	} else if c.MaxRetries == 0 {
+		c.MaxRetries = 3
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 100 * time.Millisecond
+	}
+	return c
+}
+
+// writeOp 是队列中的一次写操作
+type writeOp struct {
+	key    string
+	value  []byte
+	delete bool // 为 true 时表示删除
+}
+
+// writeBehind 在后台协程中把写操作批量写回数据源。同一批次中同一个key只保留最后一次操作
+type writeBehind struct {
+	log     *logger
+	setter  Setter
+	deleter Deleter
+	cfg     WriteBehindConfig
+	queue   chan writeOp
+	flushCh chan chan struct{} // 请求立即写回，写回完成后关闭传入的 channel
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+	once    sync.Once
+}
+
+// newWriteBehind 创建回写队列，需要调用 start 启动后台协程
+func newWriteBehind(setter Setter, cfg WriteBehindConfig) *writeBehind {
+	w := &writeBehind{
+		setter:  setter,
+		cfg:     cfg.withDefaults(),
+		flushCh: make(chan chan struct{}),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+	w.queue = make(chan writeOp, w.cfg.QueueSize)
+	return w
+}
+
+// start 启动后台协程，deleter 可以为 nil。Group 的所有选项应用完后才知道最终的 deleter，所以单独传入
+func (w *writeBehind) start(deleter Deleter, log *logger) {
+	w.deleter, w.log = deleter, log
+	go w.run()
+}
+
+// enqueue 把写操作放入队列，队列已满或已关闭时返回错误
+func (w *writeBehind) enqueue(op writeOp) error {
+	select {
+	case <-w.stopCh:
+		return fmt.Errorf("write-behind queue is closed")
+	default:
+	}
+	select {
+	case w.queue <- op:
+		return nil
+	default:
+		return fmt.Errorf("write-behind queue is full")
+	}
+}
+
+// flush 立即写回队列中的所有操作，返回时队列中在调用之前的操作都已处理完毕。ctx 结束时不再等待并返回 ctx.Err()
+func (w *writeBehind) flush(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case w.flushCh <- done:
+	case <-w.doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close 写回队列中剩余的操作并停止后台协程，可以重复调用
+func (w *writeBehind) close() {
+	w.once.Do(func() {
+		close(w.stopCh)
+	})
+	<-w.doneCh
+}
+
+// run 是后台协程的主循环：攒满一批或到达 FlushInterval 时写回
+func (w *writeBehind) run() {
+	defer close(w.doneCh)
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []writeOp
+	for {
+		select {
+		case op := <-w.queue:
+			batch = append(batch, op)
+			if len(batch) >= w.cfg.BatchSize {
+				w.write(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			w.write(batch)
+			batch = nil
+		case done := <-w.flushCh:
+			batch = w.drain(batch)
+			w.write(batch)
+			batch = nil
+			close(done)
+		case <-w.stopCh:
+			batch = w.drain(batch)
+			w.write(batch)
+			return
+		}
+	}
+}
+
+// drain 取出队列中已有的所有操作
+func (w *writeBehind) drain(batch []writeOp) []writeOp {
+	for {
+		select {
+		case op := <-w.queue:
+			batch = append(batch, op)
+		default:
+			return batch
+		}
+	}
+}
+
+// write 写回一批操作。同一个key只保留最后一次操作；如果 setter 实现了 BatchSetter，所有的写入合并为一次调用
+func (w *writeBehind) write(batch []writeOp) {
+	if len(batch) == 0 {
+		return
+	}
+	last := make(map[string]writeOp, len(batch))
+	order := make([]string, 0, len(batch))
+	for _, op := range batch {
+		if _, ok := last[op.key]; !ok {
+			order = append(order, op.key)
+		}
+		last[op.key] = op
+	}
+
+	sets := make(map[string][]byte)
+	for _, key := range order {
+		op := last[key]
+		if op.delete {
+			w.retry([]string{key}, func() error { return w.deleter.Delete(key) })
+		} else {
+			sets[key] = op.value
+		}
+	}
+	if bs, ok := w.setter.(BatchSetter); ok && len(sets) > 1 {
+		keys := make([]string, 0, len(sets))
+		for _, key := range order {
+			if _, ok := sets[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+		w.retry(keys, func() error { return bs.SetBatch(sets) })
+		return
+	}
+	for _, key := range order {
+		if value, ok := sets[key]; ok {
+			w.retry([]string{key}, func() error { return w.setter.Set(key, value) })
+		}
+	}
+}
+
+// retry 执行写入 keys 的 fn，失败时按指数退避重试，重试耗尽后对每个 key 调用 OnError
+func (w *writeBehind) retry(keys []string, fn func() error) {
+	backoff := w.cfg.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= w.cfg.MaxRetries; attempt++ {
+		if err = fn(); err == nil {
+			return
+		}
+		if attempt < w.cfg.MaxRetries {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	for _, key := range keys {
+		w.log.get().Error("write back failed", keyHash(key), "keys", len(keys), "err", err)
+		if w.cfg.OnError != nil {
+			w.cfg.OnError(key, err)
+		}
+	}
+}
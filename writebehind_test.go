@@ -0,0 +1,195 @@
+package tinycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// store 是一个线程安全的内存数据源，同时实现了 Getter、Setter、BatchSetter 和 Deleter
+type store struct {
+	mu      sync.Mutex
+	data    map[string]string
+	batches int
+	fail    int // 接下来失败的写入次数
+}
+
+func newStore() *store {
+	return &store{data: map[string]string{}}
+}
+
+func (s *store) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.data[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%s not exist", key)
+}
+
+func (s *store) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail > 0 {
+		s.fail--
+		return fmt.Errorf("store unavailable")
+	}
+	s.data[key] = string(value)
+	return nil
+}
+
+func (s *store) SetBatch(values map[string][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+	for k, v := range values {
+		s.data[k] = string(v)
+	}
+	return nil
+}
+
+func (s *store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+func (s *store) value(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func TestWriteThrough(t *testing.T) {
+	src := newStore()
+	g := NewGroup("write-through", 2<<10, "lru", src, WithWriteThrough(src))
+
+	if _, err := g.Set("Tom", []byte("630")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := src.value("Tom"); v != "630" {
+		t.Fatalf("write-through should write the source synchronously, got %q", v)
+	}
+
+	src.fail = 1
+	if _, err := g.Set("Tom", []byte("700")); err == nil {
+		t.Fatalf("failed source write should be reported")
+	}
+	if view, _ := g.Get("Tom"); view.String() != "630" {
+		t.Fatalf("failed write should not be cached, got %s", view)
+	}
+
+	if err := g.Delete("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := src.value("Tom"); ok {
+		t.Fatalf("Delete should remove the key from source")
+	}
+	if _, err := g.Get("Tom"); err == nil {
+		t.Fatalf("deleted key should not be served from cache")
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	src := newStore()
+	var (
+		errMu  sync.Mutex
+		failed []string
+	)
+	g := NewGroup("write-behind", 2<<10, "lru", src, WithWriteBehind(src, WriteBehindConfig{
+		FlushInterval: time.Hour,
+		RetryBackoff:  time.Millisecond,
+		MaxRetries:    1,
+		OnError: func(key string, err error) {
+			errMu.Lock()
+			failed = append(failed, key)
+			errMu.Unlock()
+		},
+	}))
+
+	g.Set("Tom", []byte("1"))
+	g.Set("Tom", []byte("2"))
+	g.Set("Jack", []byte("3"))
+	if view, _ := g.Get("Tom"); view.String() != "2" {
+		t.Fatalf("write-behind should cache immediately, got %s", view)
+	}
+	if _, ok := src.value("Tom"); ok {
+		t.Fatalf("write-behind should not write the source before flush")
+	}
+
+	g.FlushWrites()
+	if v, _ := src.value("Tom"); v != "2" || src.batches != 1 {
+		t.Fatalf("flush should write the latest value in one batch, got %q in %d batches", v, src.batches)
+	}
+
+	// 单条写入失败时重试，重试耗尽后回调 OnError
+	src.fail = 2
+	g.Set("Sam", []byte("4"))
+	g.FlushWrites()
+	if len(failed) != 1 || failed[0] != "Sam" {
+		t.Fatalf("exhausted retries should call OnError, got %v", failed)
+	}
+}
+
+func TestWriteBehindQueueFull(t *testing.T) {
+	w := newWriteBehind(newStore(), WriteBehindConfig{QueueSize: 1}) // 不启动后台协程，队列不会被消费
+	if err := w.enqueue(writeOp{key: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.enqueue(writeOp{key: "b"}); err == nil {
+		t.Fatalf("enqueue on a full queue should fail")
+	}
+}
+
+func TestWriteBehindFlushContext(t *testing.T) {
+	release := make(chan struct{})
+	slow := SetterFunc(func(key string, value []byte) error {
+		<-release
+		return nil
+	})
+	g := NewGroup("write-behind-ctx", 2<<10, "lru", newStore(), WithWriteBehind(slow, WriteBehindConfig{FlushInterval: time.Hour}))
+	defer func() {
+		close(release)
+		g.Close()
+	}()
+	g.Set("Tom", []byte("1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := g.flushWrites(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("flush should give up when ctx expires, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("flush should not wait for the source after ctx expires, took %v", time.Since(start))
+	}
+}
+
+// failingBatch 的批量写入总是失败
+type failingBatch struct{ *store }
+
+func (failingBatch) SetBatch(values map[string][]byte) error {
+	return fmt.Errorf("store unavailable")
+}
+
+func TestWriteBehindBatchError(t *testing.T) {
+	var failed []string
+	g := NewGroup("write-behind-batch", 2<<10, "lru", newStore(), WithWriteBehind(failingBatch{newStore()}, WriteBehindConfig{
+		FlushInterval: time.Hour,
+		MaxRetries:    -1,
+		OnError:       func(key string, err error) { failed = append(failed, key) },
+	}))
+	defer g.Close()
+	g.Set("Tom", []byte("1"))
+	g.Set("Jack", []byte("2"))
+	g.FlushWrites()
+	if !reflect.DeepEqual(failed, []string{"Tom", "Jack"}) {
+		t.Fatalf("OnError should be called for each key of the failed batch, got %v", failed)
+	}
+}